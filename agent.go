@@ -0,0 +1,54 @@
+package profilecreds
+
+import (
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// agentWriteTimeout bounds the time spent writing credentials to a client of the agent
+const agentWriteTimeout = 10 * time.Second
+
+// ServeAgent serves the credentials of the provider to each client connecting to listener, e.g. a Unix
+// socket, like ssh-agent: the MFA token is entered once for the lifetime of the agent, instead of once per
+// client. Credentials are written in the credential_process JSON format, and the connection is closed.
+// They are refreshed as needed, and concurrent clients are served at once. The socket must only be
+// accessible to its owner. ServeAgent returns when Accept fails, e.g. once listener is closed.
+func (p *AssumeRoleProfileProvider) ServeAgent(listener net.Listener) error {
+	// Credentials keeps the current credentials in memory, and serializes refreshes
+	creds := credentials.NewCredentials(p)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go p.serveAgentConn(conn, creds)
+	}
+}
+
+// serveAgentConn writes the current credentials to conn
+func (p *AssumeRoleProfileProvider) serveAgentConn(conn net.Conn, creds *credentials.Credentials) {
+	defer conn.Close()
+
+	value, err := creds.Get()
+	if err != nil {
+		p.log("profilecreds: agent:", err)
+		return
+	}
+
+	// The expiration reported by STS, clients refresh the credentials on their own before it. Long-lived
+	// credentials have no expiration.
+	expiration := p.credentialsExpiration()
+
+	out, err := AWSVaultJSON(value, expiration)
+	if err != nil {
+		p.log("profilecreds: agent:", err)
+		return
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(agentWriteTimeout))
+	conn.Write(out)
+}
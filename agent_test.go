@@ -0,0 +1,44 @@
+package profilecreds
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServeAgent(t *testing.T) {
+	expiration := time.Now().Add(time.Hour).Truncate(time.Second)
+	p := newTestProvider(t, &fakeSTS{Expiration: expiration}, testConfig, testCredentials, WithExpiryWindow(10*time.Minute))
+
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "agent.sock"))
+	if err != nil {
+		t.Skip("unix sockets unavailable:", err)
+	}
+	defer listener.Close()
+	go p.ServeAgent(listener)
+
+	conn, err := net.Dial("unix", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	data, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out processCredentials
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.AccessKeyID != "ASIA1" {
+		t.Errorf("AccessKeyId = %q, want ASIA1", out.AccessKeyID)
+	}
+	if out.Expiration == nil || !out.Expiration.Equal(expiration) {
+		t.Errorf("Expiration = %v, want %s", out.Expiration, expiration)
+	}
+}
@@ -0,0 +1,77 @@
+package profilecreds
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// The helpers below target the output formats of aws-vault v7.
+
+// processCredentials is the JSON document output by credential_process helpers, and by
+// `aws-vault export --format=json`
+type processCredentials struct {
+	Version         int
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string
+	SessionToken    string     `json:",omitempty"`
+	Expiration      *time.Time `json:",omitempty"`
+}
+
+// AWSVaultJSON returns the credentials in the JSON format of `aws-vault export --format=json`, which is
+// also the format expected from a credential_process.
+func AWSVaultJSON(v credentials.Value, expiration time.Time) ([]byte, error) {
+	out := processCredentials{
+		Version:         1,
+		AccessKeyID:     v.AccessKeyID,
+		SecretAccessKey: v.SecretAccessKey,
+		SessionToken:    v.SessionToken,
+	}
+	if !expiration.IsZero() {
+		expiration = expiration.UTC()
+		out.Expiration = &expiration
+	}
+
+	return json.Marshal(out)
+}
+
+// AWSVaultEnv returns the credentials as the KEY=VALUE environment variables set by `aws-vault exec`.
+func AWSVaultEnv(profileName string, v credentials.Value, expiration time.Time) []string {
+	env := []string{
+		"AWS_VAULT=" + profileName,
+		"AWS_ACCESS_KEY_ID=" + v.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY=" + v.SecretAccessKey,
+	}
+	if v.SessionToken != "" {
+		env = append(env,
+			"AWS_SESSION_TOKEN="+v.SessionToken,
+			"AWS_SECURITY_TOKEN="+v.SessionToken,
+		)
+	}
+	if !expiration.IsZero() {
+		env = append(env, "AWS_CREDENTIAL_EXPIRATION="+expiration.UTC().Format(time.RFC3339))
+	}
+
+	return env
+}
+
+// CommandEnv returns the credentials and region as KEY=VALUE environment variables, to be appended to the
+// Env of an exec.Cmd, e.g. to run `aws s3 ls` with the assumed credentials. Empty values are left out.
+func CommandEnv(v credentials.Value, region string) []string {
+	env := []string{
+		"AWS_ACCESS_KEY_ID=" + v.AccessKeyID,
+		"AWS_SECRET_ACCESS_KEY=" + v.SecretAccessKey,
+	}
+	if v.SessionToken != "" {
+		env = append(env, "AWS_SESSION_TOKEN="+v.SessionToken)
+	}
+	if region != "" {
+		env = append(env,
+			"AWS_REGION="+region,
+			"AWS_DEFAULT_REGION="+region,
+		)
+	}
+
+	return env
+}
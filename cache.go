@@ -1,10 +1,18 @@
 package profilecreds
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"os"
-	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 // Cache is the interface used by AssumeRoleProfileProvider to store temporary credentials
@@ -16,18 +24,92 @@ type Cache interface {
 	Get(key string) (value string, found bool)
 }
 
+// ClearableCache is implemented by caches which can remove the cached credentials
+type ClearableCache interface {
+	Cache
+
+	// Clear removes the credentials of all profiles from the cache. Other values are kept.
+	Clear() error
+}
+
+// ClearCache removes the credentials of all profiles from cache, e.g. after rotating access keys.
+// An error is returned if cache doesn't implement ClearableCache.
+func ClearCache(cache Cache) error {
+	c, ok := cache.(ClearableCache)
+	if !ok {
+		return fmt.Errorf("profilecreds: cache %T can't be cleared", cache)
+	}
+
+	return c.Clear()
+}
+
+// KeyedCache is implemented by caches which can list their keys
+type KeyedCache interface {
+	Cache
+
+	// Keys returns the keys of all the values in the cache
+	Keys() []string
+}
+
+// GetOrSetCache is implemented by caches which can get a value, or compute and set it, atomically. When
+// the cache is shared by several processes, only one of them then refreshes the credentials.
+type GetOrSetCache interface {
+	Cache
+
+	// GetOrSet returns the value of key if present and valid reports it can be used, otherwise the value
+	// returned by fn, which is set. fn may use the cache, e.g. to retrieve the credentials of another profile.
+	// Waiting for another process computing the value stops when ctx is done.
+	GetOrSet(ctx context.Context, key string, valid func(value string) bool, fn func() (string, error)) (string, error)
+}
+
+// isCredentialsKey returns whether key holds credentials
+func isCredentialsKey(key string) bool {
+	return key == legacyCacheKey || strings.HasPrefix(key, cacheKeyPrefix)
+}
+
+// defaultCacheFile returns the temporary location of the cache file for namespace
+func defaultCacheFile(namespace string) string {
+	if namespace == "" {
+		return filepath.Join(os.TempDir(), "credentials")
+	}
+
+	return filepath.Join(os.TempDir(), "credentials-"+escapeKey(namespace))
+}
+
 // FileCache is a simple implementation of Cache backed by a file
 type FileCache struct {
+	// If true, expired credentials are dropped whenever the cache file is read, and removed from the file
+	// on the next write. This keeps the secrets on disk to a minimum without calling PruneExpired.
+	PruneOnLoad bool
+
+	// Optional key of an HMAC of the content, stored in the cache file and verified when reading it. A
+	// cache file that doesn't match, e.g. after a partial write or tampering, is treated as empty. Any key
+	// detects corruption, a secret one also ensures the file was written by a holder of the key.
+	ChecksumKey []byte
+
 	m    sync.Mutex
 	data map[string]string
 
 	filename string
 }
 
-// NewFileCache returns a new instance of FileCache. If filename is "", a temporary location is chosen.
+// CacheFileEnvVar is the environment variable consulted by NewFileCache when no filename is given
+const CacheFileEnvVar = "AWS_PROFILECREDS_CACHE"
+
+// NewFileCache returns a new instance of FileCache. The location of the cache file is, in order of precedence:
+// filename if not "", the value of the AWS_PROFILECREDS_CACHE environment variable if set, or a temporary location.
 func NewFileCache(filename string) *FileCache {
+	return NewNamespaceFileCache(filename, "")
+}
+
+// NewNamespaceFileCache is like NewFileCache, but the temporary location is specific to namespace, so that
+// tools with different namespaces don't share a cache file by default.
+func NewNamespaceFileCache(filename, namespace string) *FileCache {
+	if filename == "" {
+		filename = os.Getenv(CacheFileEnvVar)
+	}
 	if filename == "" {
-		filename = path.Join(os.TempDir(), "credentials")
+		filename = defaultCacheFile(namespace)
 	}
 
 	return &FileCache{
@@ -37,52 +119,207 @@ func NewFileCache(filename string) *FileCache {
 
 // Set adds a new value to the cache, overwritting any pre-existing value
 func (f *FileCache) Set(key, value string) {
-	if f.data == nil {
-		f.readConf()
-	}
+	f.set(key, value)
+}
+
+// set adds a new value to the cache, and reports whether the cache file could be written
+func (f *FileCache) set(key, value string) error {
+	return f.update(func() {
+		f.data[key] = value
+	})
+}
 
+// update applies fn to the latest state of the cache file, and saves it. The cache file is locked meanwhile,
+// so that the keys written concurrently by other processes aren't lost.
+func (f *FileCache) update(fn func()) error {
 	f.m.Lock()
-	f.data[key] = value
-	f.m.Unlock()
+	defer f.m.Unlock()
 
-	f.writeConf()
+	unlock, err := lockFile(context.Background(), f.filename+".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f.readConf()
+	fn()
+
+	return f.writeConf()
 }
 
 // Get a value from the cache. found is false if the value wasn't present
 func (f *FileCache) Get(key string) (string, bool) {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	// The file is read on first use, data must only be checked with f.m held
 	if f.data == nil {
 		f.readConf()
 	}
+	value, found := f.data[key]
+
+	return value, found
+}
+
+// GetOrSet returns the value of key if present and valid reports it can be used, otherwise the value returned
+// by fn, which is set. A lock file specific to key is held meanwhile, so that other processes and goroutines
+// using GetOrSet for key wait for the value instead of computing it as well, until their ctx is done. The
+// lock is released if the process exits while fn runs. The cache can be used while fn runs, including
+// GetOrSet for other keys.
+func (f *FileCache) GetOrSet(ctx context.Context, key string, valid func(value string) bool, fn func() (string, error)) (string, error) {
+	unlock, err := lockFile(ctx, f.filename+"."+escapeKey(key)+".lock")
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
 
 	f.m.Lock()
+	f.readConf()
 	value, found := f.data[key]
 	f.m.Unlock()
+	if found && valid(value) {
+		return value, nil
+	}
 
-	return value, found
+	if value, err = fn(); err != nil {
+		return "", err
+	}
+	if err := f.set(key, value); err != nil {
+		return "", err
+	}
+
+	return value, nil
 }
 
-func (f *FileCache) readConf() {
+// Keys returns the keys of all the values in the cache
+func (f *FileCache) Keys() []string {
 	f.m.Lock()
 	defer f.m.Unlock()
 
+	f.readConf()
+
+	keys := make([]string, 0, len(f.data))
+	for key := range f.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Clear removes the credentials of all profiles from the cache. Other values are kept.
+func (f *FileCache) Clear() error {
+	return f.update(func() {
+		for key := range f.data {
+			if isCredentialsKey(key) {
+				delete(f.data, key)
+			}
+		}
+	})
+}
+
+// PruneExpired removes the expired credentials from the cache file, e.g. those of profiles no longer used
+func (f *FileCache) PruneExpired() error {
+	return f.update(f.pruneExpired)
+}
+
+// pruneExpired removes the expired credentials from data. f.m must be held.
+func (f *FileCache) pruneExpired() {
+	for key, value := range f.data {
+		if isExpiredCredentials(key, value) {
+			delete(f.data, key)
+		}
+	}
+}
+
+// isExpiredCredentials returns whether value holds expired credentials
+func isExpiredCredentials(key, value string) bool {
+	if !isCredentialsKey(key) {
+		return false
+	}
+
+	cached, err := decodeCreds(value)
+	return err == nil && !cached.Expiration.IsZero() && cached.Expiration.Before(time.Now())
+}
+
+// readConf loads the cache file into data. f.m must be held.
+func (f *FileCache) readConf() {
 	f.data = make(map[string]string)
 
 	file, err := os.Open(f.filename)
 	if err != nil {
 		return
 	}
+	defer file.Close()
 
 	json.NewDecoder(file).Decode(&f.data)
+	if len(f.ChecksumKey) > 0 {
+		checksum := f.data[checksumKey]
+		delete(f.data, checksumKey)
+		if !hmac.Equal([]byte(checksum), []byte(f.checksum())) {
+			f.data = make(map[string]string)
+		}
+	}
+	if f.PruneOnLoad {
+		f.pruneExpired()
+	}
 }
 
-func (f *FileCache) writeConf() {
-	f.m.Lock()
-	defer f.m.Unlock()
+// writeConf saves data to the cache file. f.m must be held.
+func (f *FileCache) writeConf() error {
+	// The parent directory may not exist yet, e.g. ~/.aws/cli/cache on a fresh machine
+	if err := os.MkdirAll(filepath.Dir(f.filename), 0700); err != nil {
+		return err
+	}
 
-	file, err := os.OpenFile(f.filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
-	if err != nil {
-		return
+	data := f.data
+	if len(f.ChecksumKey) > 0 {
+		data = make(map[string]string, len(f.data)+1)
+		for key, value := range f.data {
+			data[key] = value
+		}
+		data[checksumKey] = f.checksum()
+	}
+
+	// The file is replaced atomically: a process reading a truncated file would otherwise see an empty
+	// cache, and drop the values of other profiles on its next Set. The temporary file is only readable
+	// by the owner, as the cache holds secrets.
+	return writeFileAtomic(f.filename, data)
+}
+
+// checksumKey is the key under which the checksum is stored in the cache file
+const checksumKey = "profilecreds:checksum"
+
+// checksum returns the HMAC of data, keyed with ChecksumKey. f.m must be held.
+func (f *FileCache) checksum() string {
+	// Keys are sorted when encoding maps, which makes the encoding canonical
+	content, _ := json.Marshal(f.data)
+
+	mac := hmac.New(sha256.New, f.ChecksumKey)
+	mac.Write(content)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ReadOnlyCache wraps a Cache so that it is never written to. This is useful when the cache is
+// provisioned by a trusted process, and consumers must only read credentials from it.
+type ReadOnlyCache struct {
+	Cache
+}
+
+// NewReadOnlyCache returns a new instance of ReadOnlyCache wrapping cache
+func NewReadOnlyCache(cache Cache) *ReadOnlyCache {
+	return &ReadOnlyCache{Cache: cache}
+}
+
+// Set does nothing, the underlying cache is left untouched
+func (r *ReadOnlyCache) Set(key, value string) {}
+
+// Keys returns the keys of the underlying cache, or nil if it can't list them
+func (r *ReadOnlyCache) Keys() []string {
+	if c, ok := r.Cache.(KeyedCache); ok {
+		return c.Keys()
 	}
 
-	json.NewEncoder(file).Encode(f.data)
+	return nil
 }
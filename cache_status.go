@@ -0,0 +1,92 @@
+package profilecreds
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NextRefreshTimes returns when the credentials cached in cache will need to be refreshed, i.e. their
+// expiration minus window. Results are keyed by profile name, prefixed with the namespace and a slash
+// for credentials cached with a Namespace. An error is returned if cache doesn't implement KeyedCache.
+func NextRefreshTimes(cache Cache, window time.Duration) (map[string]time.Time, error) {
+	entries, err := cachedEntries(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	times := make(map[string]time.Time, len(entries))
+	for name, entry := range entries {
+		times[name] = entry.Expiration.Add(-window)
+	}
+
+	return times, nil
+}
+
+// CachedInfo describes credentials stored in a cache, without their secrets
+type CachedInfo struct {
+	// Name of the profile, prefixed with the namespace and a slash if cached with a Namespace
+	Name string
+
+	ProfileName string
+	RoleARN     string
+	AccountID   string
+	Expiration  time.Time
+
+	// Time left until the credentials expire, negative if they are expired
+	Remaining time.Duration
+}
+
+// ListCached describes the credentials cached in cache, sorted by name. An error is returned if cache
+// doesn't implement KeyedCache.
+func ListCached(cache Cache) ([]CachedInfo, error) {
+	entries, err := cachedEntries(cache)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]CachedInfo, 0, len(entries))
+	for name, entry := range entries {
+		infos = append(infos, CachedInfo{
+			Name:        name,
+			ProfileName: entry.Profile.Name,
+			RoleARN:     entry.Profile.RoleARN,
+			AccountID:   entry.AccountID,
+			Expiration:  entry.Expiration,
+			Remaining:   time.Until(entry.Expiration),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+	return infos, nil
+}
+
+// cachedEntries decodes the credentials cached in cache, keyed by namespace and profile name.
+// Values which can't be decoded are skipped.
+func cachedEntries(cache Cache) (map[string]*creds, error) {
+	c, ok := cache.(KeyedCache)
+	if !ok {
+		return nil, fmt.Errorf("profilecreds: cache %T can't list its keys", cache)
+	}
+
+	entries := make(map[string]*creds)
+	for _, key := range c.Keys() {
+		if !strings.HasPrefix(key, cacheKeyPrefix) {
+			continue
+		}
+
+		cachedJSON, found := c.Get(key)
+		if !found {
+			continue
+		}
+
+		entry, err := decodeCreds(cachedJSON)
+		if err != nil {
+			continue
+		}
+		entries[strings.TrimPrefix(key, cacheKeyPrefix)] = entry
+	}
+
+	return entries, nil
+}
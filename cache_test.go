@@ -0,0 +1,290 @@
+package profilecreds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCredsJSON returns cached credentials expiring at expiration
+func testCredsJSON(t *testing.T, expiration time.Time) string {
+	t.Helper()
+
+	data, err := json.Marshal(&creds{Version: cacheVersion, Expiration: expiration, Profile: Profile{Name: "prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestPruneExpired(t *testing.T) {
+	filename := t.TempDir() + "/cache"
+	cache := NewFileCache(filename)
+	cache.Set(cacheKeyPrefix+"expired", testCredsJSON(t, time.Now().Add(-time.Minute)))
+	cache.Set(cacheKeyPrefix+"valid", testCredsJSON(t, time.Now().Add(time.Hour)))
+	cache.Set("other", "value")
+
+	if err := cache.PruneExpired(); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := NewFileCache(filename).Keys()
+	if len(keys) != 2 || keys[0] != cacheKeyPrefix+"valid" || keys[1] != "other" {
+		t.Errorf("keys = %v, want the valid credentials and the other value", keys)
+	}
+}
+
+func TestPruneOnLoad(t *testing.T) {
+	filename := t.TempDir() + "/cache"
+	cache := NewFileCache(filename)
+	cache.Set(cacheKeyPrefix+"expired", testCredsJSON(t, time.Now().Add(-time.Minute)))
+	cache.Set(cacheKeyPrefix+"valid", testCredsJSON(t, time.Now().Add(time.Hour)))
+
+	pruning := NewFileCache(filename)
+	pruning.PruneOnLoad = true
+	if _, found := pruning.Get(cacheKeyPrefix + "expired"); found {
+		t.Error("expired credentials found")
+	}
+	if _, found := pruning.Get(cacheKeyPrefix + "valid"); !found {
+		t.Error("valid credentials not found")
+	}
+
+	// Expired credentials are removed from the file on the next write
+	pruning.Set("other", "value")
+	if _, found := NewFileCache(filename).Get(cacheKeyPrefix + "expired"); found {
+		t.Error("expired credentials still in the file")
+	}
+}
+
+func TestGetOrSetReentrant(t *testing.T) {
+	cache := NewFileCache(t.TempDir() + "/cache")
+	always := func(string) bool { return true }
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := cache.GetOrSet(context.Background(), "outer", always, func() (string, error) {
+			cache.Get("inner")
+			cache.Set("other", "value")
+			return cache.GetOrSet(context.Background(), "inner", always, func() (string, error) {
+				return "inner", nil
+			})
+		})
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetOrSet deadlocked when fn uses the cache")
+	}
+
+	for key, want := range map[string]string{"outer": "inner", "inner": "inner", "other": "value"} {
+		if value, _ := cache.Get(key); value != want {
+			t.Errorf("%s = %q, want %q", key, value, want)
+		}
+	}
+}
+
+func TestGetOrSetInvalid(t *testing.T) {
+	filename := t.TempDir() + "/cache"
+	NewFileCache(filename).Set("key", "old")
+	valid := func(value string) bool { return value != "old" }
+
+	var m sync.Mutex
+	calls := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Each goroutine has its own FileCache, like separate processes
+			value, err := NewFileCache(filename).GetOrSet(context.Background(), "key", valid, func() (string, error) {
+				m.Lock()
+				calls++
+				m.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				return "new", nil
+			})
+			if err != nil || value != "new" {
+				t.Errorf("GetOrSet = %q, %v, want new", value, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("fn called %d times, want 1", calls)
+	}
+}
+
+func TestGetOrSetContext(t *testing.T) {
+	filename := t.TempDir() + "/cache"
+
+	unlock, err := lockFile(context.Background(), filename+".key.lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err = NewFileCache(filename).GetOrSet(ctx, "key", func(string) bool { return true }, func() (string, error) {
+		return "value", nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetOrSet = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestGetOrSetTimeout(t *testing.T) {
+	cache := NewFileCache(t.TempDir() + "/cache")
+	p := newTestProvider(t, &fakeSTS{}, testConfig, testCredentials, func(p *AssumeRoleProfileProvider) {
+		p.Cache = cache
+		p.Timeout = 100 * time.Millisecond
+	})
+
+	// Another process is retrieving the credentials
+	unlock, err := lockFile(context.Background(), cache.filename+"."+escapeKey(p.cacheKey())+".lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Retrieve()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Retrieve = %v, want %v", err, context.DeadlineExceeded)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Retrieve still waiting for the lock after Timeout")
+	}
+}
+
+func TestLockFileReleasedOnExit(t *testing.T) {
+	// The lock is taken by a child process, which exits without releasing it
+	if path := os.Getenv("PROFILECREDS_TEST_LOCK"); path != "" {
+		if _, err := lockFile(context.Background(), path); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	path := t.TempDir() + "/cache.lock"
+	cmd := exec.Command(os.Args[0], "-test.run=^TestLockFileReleasedOnExit$")
+	cmd.Env = append(os.Environ(), "PROFILECREDS_TEST_LOCK="+path)
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	unlock, err := lockFile(ctx, path)
+	if err != nil {
+		t.Fatalf("lock of an exited process not released: %v", err)
+	}
+	unlock()
+}
+
+func TestGetOrSetIntermediateSessions(t *testing.T) {
+	fake := &fakeSTS{}
+	p := newTestProvider(t, fake, testChainedConfig, testCredentials, func(p *AssumeRoleProfileProvider) {
+		p.Cache = NewFileCache(t.TempDir() + "/cache")
+		p.CacheIntermediateSessions = true
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.Retrieve()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Retrieve deadlocked")
+	}
+
+	keys := p.Cache.(KeyedCache).Keys()
+	if len(keys) != 2 || keys[0] != cacheKeyPrefix+"base" || keys[1] != cacheKeyPrefix+"prod" {
+		t.Errorf("keys = %v, want the base and prod sessions", keys)
+	}
+}
+
+func TestFileCacheConcurrent(t *testing.T) {
+	filename := t.TempDir() + "/cache"
+
+	// Several instances share the file, like separate processes
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		cache := NewFileCache(filename)
+		for j := 0; j < 10; j++ {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+
+				cache.Set(key, key)
+				if value, found := cache.Get(key); !found || value != key {
+					t.Errorf("Get(%s) = %q, %t", key, value, found)
+				}
+				cache.Keys()
+			}(fmt.Sprintf("key-%d-%d", i, j))
+		}
+	}
+	wg.Wait()
+
+	if keys := NewFileCache(filename).Keys(); len(keys) != 40 {
+		t.Errorf("%d keys, want 40: %v", len(keys), keys)
+	}
+}
+
+func TestFileCacheConcurrentFirstAccess(t *testing.T) {
+	filename := t.TempDir() + "/cache"
+	NewFileCache(filename).Set("existing", "value")
+
+	// No goroutine has read the file yet
+	cache := NewFileCache(filename)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+
+			if value, found := cache.Get("existing"); !found || value != "value" {
+				t.Errorf("Get = %q, %t", value, found)
+			}
+		}()
+		go func(key string) {
+			defer wg.Done()
+
+			cache.Set(key, key)
+		}(fmt.Sprintf("key-%d", i))
+	}
+	wg.Wait()
+
+	if keys := NewFileCache(filename).Keys(); len(keys) != 21 {
+		t.Errorf("%d keys, want 21: %v", len(keys), keys)
+	}
+}
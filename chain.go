@@ -0,0 +1,78 @@
+package profilecreds
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// AssumeChain assumes each of the roles in turn, using the credentials of a role to assume the next one,
+// and returns the credentials of the last role. No profile is read: the first role is assumed with the
+// SourceCredentials set by the options, or else the default credentials of the SDK. MFA only applies to
+// the first role, using the PreferredMFASerial device if set, and WithRoleARN is ignored. The session
+// policies, StatusFile and Events only apply to the last role, like in a chain of profiles. The returned
+// expiration is the earliest of the chain, as later roles can't outlive the credentials used to assume
+// them. Nothing is cached.
+func AssumeChain(roleARNs []string, options ...func(*AssumeRoleProfileProvider)) (credentials.Value, time.Time, error) {
+	if len(roleARNs) == 0 {
+		return credentials.Value{ProviderName: ProviderName}, time.Time{}, errors.New("profilecreds: no role to assume")
+	}
+
+	var (
+		source     *credentials.Credentials
+		value      credentials.Value
+		expiration time.Time
+	)
+	for i, roleARN := range roleARNs {
+		p := newProvider(fmt.Sprintf("chain-%d", i), options...)
+		p.Cache = nil
+		p.roleARN = ""
+
+		prof := Profile{Name: p.ProfileName, RoleARN: roleARN}
+		if i == 0 {
+			if p.PreferredMFASerial != "" {
+				prof.MFASerial = aws.String(p.PreferredMFASerial)
+			}
+
+			source = p.SourceCredentials
+			if source == nil {
+				sess, err := session.NewSession()
+				if err != nil {
+					return credentials.Value{ProviderName: ProviderName}, time.Time{}, err
+				}
+				source = sess.Config.Credentials
+			}
+		} else {
+			p.RequireMFA = false
+			p.DiscoverMFASerial = false
+		}
+		if i < len(roleARNs)-1 {
+			p.Policy = nil
+			p.PolicyArns = nil
+			p.StatusFile = ""
+			p.Events = nil
+		}
+
+		p.SourceCredentials = source
+		p.ProfileLoader = func(string) (*Profile, error) {
+			loaded := prof
+			return &loaded, nil
+		}
+
+		var err error
+		if value, err = p.Retrieve(); err != nil {
+			return credentials.Value{ProviderName: ProviderName}, time.Time{}, fmt.Errorf("profilecreds: assuming %s: %w", roleARN, err)
+		}
+		if expiresAt := p.credentialsExpiration(); expiration.IsZero() || expiresAt.Before(expiration) {
+			expiration = expiresAt
+		}
+
+		source = credentials.NewStaticCredentialsFromCreds(value)
+	}
+
+	return value, expiration, nil
+}
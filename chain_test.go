@@ -0,0 +1,70 @@
+package profilecreds
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+func TestAssumeChain(t *testing.T) {
+	expiration := time.Now().Add(time.Hour).Truncate(time.Second)
+	fake := &fakeSTS{Expiration: expiration}
+	roles := []string{"arn:aws:iam::123456789012:role/first", "arn:aws:iam::123456789012:role/second"}
+
+	value, got, err := AssumeChain(roles,
+		withTestFiles(t, fake, "", ""),
+		WithSourceCredentials(credentials.NewStaticCredentials("AKIDDEV", "SECRETDEV", "")),
+		WithExpiryWindow(10*time.Minute),
+		WithRoleARN("arn:aws:iam::123456789012:role/other"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.AccessKeyID != "ASIA2" {
+		t.Errorf("AccessKeyID = %q, want ASIA2", value.AccessKeyID)
+	}
+	// The expiration of the credentials, regardless of when they would be refreshed
+	if !got.Equal(expiration) {
+		t.Errorf("expiration = %s, want %s", got, expiration)
+	}
+
+	requests := fake.Requests("AssumeRole")
+	if len(requests) != 2 {
+		t.Fatalf("%d AssumeRole calls, want 2", len(requests))
+	}
+	for i, r := range requests {
+		if got := r.Form.Get("RoleArn"); got != roles[i] {
+			t.Errorf("call %d assumed %s, want %s", i, got, roles[i])
+		}
+	}
+	if got := requests[1].AccessKeyID(); got != "ASIA1" {
+		t.Errorf("second call signed with %q, want ASIA1", got)
+	}
+}
+
+func TestAssumeChainPolicy(t *testing.T) {
+	fake := &fakeSTS{}
+	roles := []string{"arn:aws:iam::123456789012:role/first", "arn:aws:iam::123456789012:role/second"}
+
+	_, _, err := AssumeChain(roles,
+		withTestFiles(t, fake, "", ""),
+		WithSourceCredentials(credentials.NewStaticCredentials("AKIDDEV", "SECRETDEV", "")),
+		WithServiceScope("s3"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first role must still be allowed to assume the second one
+	requests := fake.Requests("AssumeRole")
+	if len(requests) != 2 {
+		t.Fatalf("%d AssumeRole calls, want 2", len(requests))
+	}
+	if policy := requests[0].Form.Get("Policy"); policy != "" {
+		t.Errorf("first call with policy %q", policy)
+	}
+	if requests[1].Form.Get("Policy") == "" {
+		t.Error("second call without policy")
+	}
+}
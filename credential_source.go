@@ -0,0 +1,139 @@
+package profilecreds
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/go-ini/ini"
+	"github.com/mitchellh/go-homedir"
+)
+
+// imdsTimeout bounds each call to the instance metadata service. It is short because the service is
+// local, and so that running off EC2 fails quickly.
+const imdsTimeout = 2 * time.Second
+
+// ErrInstanceMetadataUnavailable is returned when credential_source is Ec2InstanceMetadata, but the
+// instance metadata service can't be reached, e.g. when not running on EC2.
+var ErrInstanceMetadataUnavailable = errors.New("profilecreds: EC2 instance metadata service unavailable")
+
+// sourceCredentials returns the credentials used to assume the role: SourceCredentials if set,
+// the credential_source of the profile if set, or the credentials of the source profile.
+func (p *AssumeRoleProfileProvider) sourceCredentials(prof *Profile) (*credentials.Credentials, error) {
+	if p.SourceCredentials != nil {
+		return p.SourceCredentials, nil
+	}
+
+	switch prof.CredentialSource {
+	case "":
+	case "Ec2InstanceMetadata":
+		return instanceMetadataCredentials()
+	case "Environment":
+		return credentials.NewEnvCredentials(), nil
+	default:
+		return nil, fmt.Errorf("profilecreds: unsupported credential_source %q", prof.CredentialSource)
+	}
+
+	if prof.chained {
+		return p.chainedCredentials(prof)
+	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	filename := p.credentialsFile(home)
+
+	// The SDK only reads aws_session_token, so profiles written by legacy tools are read here
+	if credsFile, err := ini.Load(filename); err == nil {
+		if section, err := credsFile.GetSection(prof.SourceProfileName); err == nil &&
+			section.HasKey("aws_security_token") && !section.HasKey("aws_session_token") {
+			value, err := staticCredentials(profileSection{section})
+			if err != nil {
+				return nil, err
+			}
+
+			return credentials.NewStaticCredentialsFromCreds(*value), nil
+		}
+	}
+
+	return credentials.NewSharedCredentials(filename, prof.SourceProfileName), nil
+}
+
+// chainedCredentials returns the credentials of the source profile, which assumes a role itself. The
+// intermediate session is only cached if CacheIntermediateSessions is true.
+func (p *AssumeRoleProfileProvider) chainedCredentials(prof *Profile) (*credentials.Credentials, error) {
+	chain := append(append([]string(nil), p.chain...), p.ProfileName)
+	for _, name := range chain {
+		if name == prof.SourceProfileName {
+			return nil, fmt.Errorf("profilecreds: source_profile cycle: %s -> %s", strings.Join(chain, " -> "), name)
+		}
+	}
+
+	// The settings apply to every role of the chain, except those specific to the final credentials
+	source := p.clone()
+	source.ProfileName = prof.SourceProfileName
+	source.chain = chain
+	source.Expiry = credentials.Expiry{CurrentTime: p.now}
+	source.Policy = nil
+	source.PolicyArns = nil
+	source.StatusFile = ""
+	source.Events = nil
+	if !p.CacheIntermediateSessions {
+		source.Cache = nil
+	}
+
+	p.m.Lock()
+	if p.config != nil {
+		source.ConfigReader = bytes.NewReader(p.config)
+	}
+	p.m.Unlock()
+
+	return credentials.NewCredentials(source), nil
+}
+
+// clone returns a new provider with the settings of p, i.e. its exported fields, but none of its state. The
+// provider holds locks, so it can't be copied as a whole.
+func (p *AssumeRoleProfileProvider) clone() *AssumeRoleProfileProvider {
+	c := &AssumeRoleProfileProvider{}
+
+	src, dst := reflect.ValueOf(p).Elem(), reflect.ValueOf(c).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if src.Type().Field(i).PkgPath == "" {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	c.now = p.now
+
+	return c
+}
+
+// instanceMetadataCredentials returns the credentials of the role of the EC2 instance. Only IMDSv2 is
+// used, as IMDSv1 is disabled in many environments. In containers, the hop limit of the instance must allow
+// the token response to reach the container, otherwise the service is reported unavailable.
+func instanceMetadataCredentials() (*credentials.Credentials, error) {
+	sess, err := session.NewSession(&aws.Config{
+		HTTPClient:                &http.Client{Timeout: imdsTimeout},
+		EC2MetadataEnableFallback: aws.Bool(false),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client := ec2metadata.New(sess)
+	if !client.Available() {
+		return nil, ErrInstanceMetadataUnavailable
+	}
+
+	return ec2rolecreds.NewCredentialsWithClient(client), nil
+}
@@ -0,0 +1,142 @@
+package profilecreds
+
+import (
+	"strings"
+	"testing"
+)
+
+const testChainedConfig = `
+[profile prod]
+role_arn = arn:aws:iam::123456789012:role/prod
+source_profile = base
+
+[profile base]
+role_arn = arn:aws:iam::123456789012:role/base
+source_profile = dev
+`
+
+func TestSharedCredentialsFile(t *testing.T) {
+	envCredentials := `
+[dev]
+aws_access_key_id = AKIDENV
+aws_secret_access_key = SECRETENV
+`
+	tests := []struct {
+		name     string
+		override bool
+		want     string
+	}{
+		{"environment", false, "AKIDENV"},
+		{"override", true, "AKIDDEV"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeSTS{}
+			p := newTestProvider(t, fake, testConfig, testCredentials)
+			if !tt.override {
+				p.CredentialsFile = ""
+			}
+			t.Setenv("AWS_SHARED_CREDENTIALS_FILE", writeTestFile(t, t.TempDir(), "credentials", envCredentials))
+
+			if _, err := p.Retrieve(); err != nil {
+				t.Fatal(err)
+			}
+
+			requests := fake.Requests("AssumeRole")
+			if len(requests) != 1 {
+				t.Fatalf("%d AssumeRole calls, want 1", len(requests))
+			}
+			if got := requests[0].AccessKeyID(); got != tt.want {
+				t.Errorf("signed with %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChainedCredentials(t *testing.T) {
+	fake := &fakeSTS{}
+	p := newTestProvider(t, fake, testChainedConfig, testCredentials, WithServiceScope("s3"))
+
+	value, err := p.Retrieve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.AccessKeyID != "ASIA2" {
+		t.Errorf("AccessKeyID = %q, want ASIA2", value.AccessKeyID)
+	}
+
+	// The intermediate session goes through HTTPClient too, without the session policy of the final role
+	requests := fake.Requests("AssumeRole")
+	if len(requests) != 2 {
+		t.Fatalf("%d AssumeRole calls, want 2", len(requests))
+	}
+	if got := requests[0].Form.Get("RoleArn"); got != "arn:aws:iam::123456789012:role/base" || requests[0].Form.Get("Policy") != "" {
+		t.Errorf("first call assumed %s with policy %q", got, requests[0].Form.Get("Policy"))
+	}
+	if got := requests[1].Form.Get("RoleArn"); got != "arn:aws:iam::123456789012:role/prod" || requests[1].Form.Get("Policy") == "" {
+		t.Errorf("second call assumed %s with policy %q", got, requests[1].Form.Get("Policy"))
+	}
+	if got := requests[1].AccessKeyID(); got != "ASIA1" {
+		t.Errorf("second call signed with %q, want ASIA1", got)
+	}
+}
+
+func TestChainedCredentialsRequireMFA(t *testing.T) {
+	config := strings.Replace(testChainedConfig, "source_profile = base", "source_profile = base\nmfa_serial = arn:aws:iam::123456789012:mfa/user", 1)
+	fake := &fakeSTS{}
+	p := newTestProvider(t, fake, config, testCredentials, func(p *AssumeRoleProfileProvider) {
+		p.RequireMFA = true
+	})
+
+	if _, err := p.Retrieve(); err == nil || !strings.Contains(err.Error(), `profile "base" has no mfa_serial`) {
+		t.Errorf("err = %v, want an MFA required error for the base profile", err)
+	}
+	if n := len(fake.Requests("AssumeRole")); n != 0 {
+		t.Errorf("%d AssumeRole calls, want 0", n)
+	}
+}
+
+func TestGovCloudRegion(t *testing.T) {
+	fake := &fakeSTS{}
+	p := newTestProvider(t, fake, testChainedConfig, testCredentials, func(p *AssumeRoleProfileProvider) {
+		p.Region = "us-gov-west-1"
+	})
+
+	if _, err := p.Retrieve(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both the source session and the final role are assumed in the region
+	requests := fake.Requests("AssumeRole")
+	if len(requests) != 2 {
+		t.Fatalf("%d AssumeRole calls, want 2", len(requests))
+	}
+	for i, r := range requests {
+		if r.Host != "sts.us-gov-west-1.amazonaws.com" || r.SigningRegion() != "us-gov-west-1" {
+			t.Errorf("call %d sent to %s, signed for %s", i, r.Host, r.SigningRegion())
+		}
+	}
+}
+
+func TestLegacySecurityToken(t *testing.T) {
+	legacyCredentials := `
+[dev]
+aws_access_key_id = ASIADEV
+aws_secret_access_key = SECRETDEV
+aws_security_token = LEGACYTOKEN
+`
+	fake := &fakeSTS{}
+	p := newTestProvider(t, fake, testConfig, legacyCredentials)
+
+	if _, err := p.Retrieve(); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := fake.Requests("AssumeRole")
+	if len(requests) != 1 {
+		t.Fatalf("%d AssumeRole calls, want 1", len(requests))
+	}
+	if got := requests[0].Header.Get("X-Amz-Security-Token"); got != "LEGACYTOKEN" {
+		t.Errorf("session token = %q, want LEGACYTOKEN", got)
+	}
+}
@@ -0,0 +1,139 @@
+package profilecreds
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DirCache is an implementation of Cache storing each value in its own file, under a directory.
+// Removing the cached credentials of a profile is as simple as deleting the corresponding file.
+type DirCache struct {
+	m   sync.Mutex
+	dir string
+}
+
+// NewDirCache returns a new instance of DirCache storing values under dir, which is created if needed.
+func NewDirCache(dir string) *DirCache {
+	return &DirCache{
+		dir: dir,
+	}
+}
+
+// Set adds a new value to the cache, overwritting any pre-existing value
+func (d *DirCache) Set(key, value string) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if err := os.MkdirAll(d.dir, 0700); err != nil {
+		return
+	}
+
+	ioutil.WriteFile(d.filename(key), []byte(value), 0600)
+}
+
+// Get a value from the cache. found is false if the value wasn't present
+func (d *DirCache) Get(key string) (string, bool) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	data, err := ioutil.ReadFile(d.filename(key))
+	if err != nil {
+		return "", false
+	}
+
+	return string(data), true
+}
+
+// Clear removes the credentials of all profiles from the cache. Other values are kept.
+func (d *DirCache) Clear() error {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	matches, err := filepath.Glob(filepath.Join(d.dir, escapeKey(cacheKeyPrefix)+"*.json"))
+	if err != nil {
+		return err
+	}
+	matches = append(matches, d.filename(legacyCacheKey))
+
+	for _, match := range matches {
+		if err := os.Remove(match); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Keys returns the keys of all the values in the cache
+func (d *DirCache) Keys() []string {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	infos, err := ioutil.ReadDir(d.dir)
+	if err != nil {
+		return nil
+	}
+
+	var keys []string
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		if key, err := unescapeKey(strings.TrimSuffix(name, ".json")); err == nil {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// filename returns the name of the file holding the value of key
+func (d *DirCache) filename(key string) string {
+	return filepath.Join(d.dir, escapeKey(key)+".json")
+}
+
+// escapeKey makes key safe to use as a file name, by escaping any character other than
+// ASCII letters, digits, '.', '_' and '-' as %XX.
+func escapeKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '.', c == '_', c == '-':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// unescapeKey reverses escapeKey
+func unescapeKey(name string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '%' {
+			b.WriteByte(name[i])
+			continue
+		}
+
+		if i+2 >= len(name) {
+			return "", fmt.Errorf("profilecreds: invalid escaped key %q", name)
+		}
+		c, err := strconv.ParseUint(name[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("profilecreds: invalid escaped key %q", name)
+		}
+		b.WriteByte(byte(c))
+		i += 2
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,81 @@
+package profilecreds
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+)
+
+// encryptedCache wraps a Cache, encrypting values with AES-GCM
+type encryptedCache struct {
+	inner Cache
+	aead  cipher.AEAD
+}
+
+// EncryptedCache wraps inner so that values are encrypted at rest with AES-GCM. The AES-256 key is
+// derived from key with SHA-256, so key may be of any length. Values which can't be decrypted, e.g.
+// because they were written with another key, are reported as not found.
+func EncryptedCache(inner Cache, key []byte) Cache {
+	sum := sha256.Sum256(key)
+
+	// Neither can fail with a 32 bytes key
+	block, _ := aes.NewCipher(sum[:])
+	aead, _ := cipher.NewGCM(block)
+
+	return &encryptedCache{
+		inner: inner,
+		aead:  aead,
+	}
+}
+
+// Set encrypts value and adds it to the inner cache
+func (e *encryptedCache) Set(key, value string) {
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+
+	// The key is used as additional data, so that values can't be swapped between keys
+	sealed := e.aead.Seal(nonce, nonce, []byte(value), []byte(key))
+
+	e.inner.Set(key, base64.StdEncoding.EncodeToString(sealed))
+}
+
+// Get a value from the inner cache and decrypts it. found is false if the value wasn't present
+// or couldn't be decrypted.
+func (e *encryptedCache) Get(key string) (string, bool) {
+	encoded, found := e.inner.Get(key)
+	if !found {
+		return "", false
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < e.aead.NonceSize() {
+		return "", false
+	}
+
+	nonce, ciphertext := sealed[:e.aead.NonceSize()], sealed[e.aead.NonceSize():]
+	value, err := e.aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return "", false
+	}
+
+	return string(value), true
+}
+
+// Clear removes the credentials of all profiles from the inner cache, if it supports it
+func (e *encryptedCache) Clear() error {
+	return ClearCache(e.inner)
+}
+
+// Keys returns the keys of the inner cache, or nil if it can't list them
+func (e *encryptedCache) Keys() []string {
+	if c, ok := e.inner.(KeyedCache); ok {
+		return c.Keys()
+	}
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package profilecreds
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// SourceProfileNotFoundError is returned when the source_profile referenced by a profile
+// is defined neither in the AWS CLI config file nor in the shared credentials file.
+type SourceProfileNotFoundError struct {
+	// Profile being loaded
+	Profile string
+
+	// Name of the missing source profile
+	SourceProfile string
+}
+
+func (e *SourceProfileNotFoundError) Error() string {
+	return fmt.Sprintf("profilecreds: source profile %q of profile %q not found", e.SourceProfile, e.Profile)
+}
+
+// DurationTooShortError is returned when Duration is below the STS minimum and StrictDuration is set.
+type DurationTooShortError struct {
+	// Requested duration
+	Duration time.Duration
+}
+
+func (e *DurationTooShortError) Error() string {
+	return fmt.Sprintf("profilecreds: duration %s is below the STS minimum of %s", e.Duration, minDuration)
+}
+
+// stsError wraps an error returned by STS, keeping it in the error chain
+type stsError struct {
+	// Original error returned by STS
+	Err awserr.Error
+}
+
+func (e *stsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *stsError) Unwrap() error {
+	return e.Err
+}
+
+// AccessDeniedError is returned when STS denies the AssumeRole call, e.g. because the trust policy of
+// the role doesn't allow the source credentials to assume it.
+type AccessDeniedError struct{ stsError }
+
+// MFAInvalidError is returned when STS rejects the MFA token. Prompting for a new token may help.
+type MFAInvalidError struct{ stsError }
+
+// ExpiredSourceCredentialsError is returned when the source credentials used to call STS are expired.
+type ExpiredSourceCredentialsError struct{ stsError }
+
+// ThrottlingError is returned when the AssumeRole call is throttled. Backing off before retrying may help.
+type ThrottlingError struct{ stsError }
+
+// SourceIdentityRejectedError is returned when the trust policy of the role doesn't allow setting
+// the requested source identity.
+type SourceIdentityRejectedError struct{ stsError }
+
+// ExternalIDRequiredError is returned when STS denies the AssumeRole call because of the external ID,
+// usually because the trust policy of the role requires one. Adding external_id to the profile may help.
+type ExternalIDRequiredError struct{ stsError }
+
+// classifySTSError wraps well-known STS errors in typed errors, so that callers can use errors.As
+// to react to them. Other errors are returned unchanged.
+func classifySTSError(err error) error {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return err
+	}
+
+	switch aerr.Code() {
+	case "AccessDenied":
+		if strings.Contains(aerr.Message(), "MultiFactorAuthentication") {
+			return &MFAInvalidError{stsError{aerr}}
+		}
+		if strings.Contains(aerr.Message(), "sts:SetSourceIdentity") {
+			return &SourceIdentityRejectedError{stsError{aerr}}
+		}
+		if msg := strings.ToLower(aerr.Message()); strings.Contains(msg, "external id") || strings.Contains(msg, "externalid") {
+			return &ExternalIDRequiredError{stsError{aerr}}
+		}
+		return &AccessDeniedError{stsError{aerr}}
+	case "ExpiredToken", sts.ErrCodeExpiredTokenException:
+		return &ExpiredSourceCredentialsError{stsError{aerr}}
+	case "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException":
+		return &ThrottlingError{stsError{aerr}}
+	}
+
+	return err
+}
+
+// ErrAutoRefreshStarted is returned by StartAutoRefresh when it has already been called for the provider.
+var ErrAutoRefreshStarted = errors.New("profilecreds: auto refresh already started")
+
+// ErrInteractiveMFA is returned when refreshing credentials in the background requires an MFA token,
+// but no GetToken source is set. The default source prompts the user, which can't be done in the background.
+var ErrInteractiveMFA = errors.New("profilecreds: MFA token required, but no non-interactive token source is set")
+
+// ErrRefreshRequired is returned by RetrieveCachedOnly when no valid credentials are cached.
+var ErrRefreshRequired = errors.New("profilecreds: no valid cached credentials, refresh required")
+
+// ErrInvalidSourceIdentity is returned when the source identity doesn't match the pattern accepted by STS.
+var ErrInvalidSourceIdentity = errors.New("profilecreds: invalid source identity, expected 2 to 64 characters in [\\w+=,.@-]")
+
+// ErrMFARequired is returned when RequireMFA is set, but the profile doesn't use MFA.
+var ErrMFARequired = errors.New("profilecreds: MFA required")
@@ -0,0 +1,64 @@
+package profilecreds
+
+import "time"
+
+// Phase is a step of the retrieval of credentials
+type Phase int
+
+const (
+	// PhaseLoadProfile is emitted before reading the profile
+	PhaseLoadProfile Phase = iota
+	// PhaseCacheHit is emitted when valid credentials are found in the cache
+	PhaseCacheHit
+	// PhasePromptMFA is emitted before requesting an MFA token from the token source
+	PhasePromptMFA
+	// PhaseCallSTS is emitted before each call to STS
+	PhaseCallSTS
+	// PhaseCacheWrite is emitted after the new credentials are stored in the cache
+	PhaseCacheWrite
+	// PhaseDone is emitted when the retrieval is over. Err is set if it failed.
+	PhaseDone
+)
+
+// String returns the name of the phase
+func (p Phase) String() string {
+	switch p {
+	case PhaseLoadProfile:
+		return "LoadProfile"
+	case PhaseCacheHit:
+		return "CacheHit"
+	case PhasePromptMFA:
+		return "PromptMFA"
+	case PhaseCallSTS:
+		return "CallSTS"
+	case PhaseCacheWrite:
+		return "CacheWrite"
+	case PhaseDone:
+		return "Done"
+	}
+
+	return "Unknown"
+}
+
+// Event reports the progress of a retrieval, see AssumeRoleProfileProvider.Events
+type Event struct {
+	Phase       Phase
+	ProfileName string
+	Time        time.Time
+
+	// Error of the retrieval, only set for PhaseDone
+	Err error
+}
+
+// emit sends an event to Events if set. The event is dropped if the channel is full, so that a slow
+// consumer never blocks the retrieval.
+func (p *AssumeRoleProfileProvider) emit(phase Phase, err error) {
+	if p.Events == nil {
+		return
+	}
+
+	select {
+	case p.Events <- Event{Phase: phase, ProfileName: p.ProfileName, Time: p.currentTime(), Err: err}:
+	default:
+	}
+}
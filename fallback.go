@@ -0,0 +1,83 @@
+package profilecreds
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// FallbackProvider assumes the first profile that succeeds among several, e.g. a primary role
+// and a break-glass role.
+type FallbackProvider struct {
+	// Providers to try, in order
+	Providers []*AssumeRoleProfileProvider
+
+	m       sync.Mutex
+	current *AssumeRoleProfileProvider
+}
+
+// NewFallbackCredentials returns a pointer to a new Credentials object retrieved by assuming the first
+// profile that succeeds among profileNames, tried in order. The options apply to every profile.
+func NewFallbackCredentials(profileNames []string, options ...func(*AssumeRoleProfileProvider)) *credentials.Credentials {
+	return credentials.NewCredentials(NewFallbackProvider(profileNames, options...))
+}
+
+// NewFallbackProvider returns a new FallbackProvider for profileNames, tried in order.
+// The options apply to every profile.
+func NewFallbackProvider(profileNames []string, options ...func(*AssumeRoleProfileProvider)) *FallbackProvider {
+	f := &FallbackProvider{}
+	for _, profileName := range profileNames {
+		f.Providers = append(f.Providers, newProvider(profileName, options...))
+	}
+
+	return f
+}
+
+// Retrieve returns the credentials of the first profile that can be assumed. If none can,
+// the errors of all profiles are returned.
+func (f *FallbackProvider) Retrieve() (credentials.Value, error) {
+	var msgs []string
+	for _, p := range f.Providers {
+		value, err := p.Retrieve()
+		if err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", p.ProfileName, err))
+			continue
+		}
+
+		f.m.Lock()
+		f.current = p
+		f.m.Unlock()
+
+		return value, nil
+	}
+
+	if len(msgs) == 0 {
+		return credentials.Value{ProviderName: ProviderName}, errors.New("profilecreds: no profile to assume")
+	}
+
+	return credentials.Value{ProviderName: ProviderName}, fmt.Errorf("profilecreds: all profiles failed: %s", strings.Join(msgs, "; "))
+}
+
+// IsExpired returns if the credentials of the profile last assumed are expired, or if none was assumed yet.
+func (f *FallbackProvider) IsExpired() bool {
+	f.m.Lock()
+	current := f.current
+	f.m.Unlock()
+
+	return current == nil || current.IsExpired()
+}
+
+// ProfileName returns the name of the profile last assumed, or "" if none was assumed yet.
+func (f *FallbackProvider) ProfileName() string {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	if f.current == nil {
+		return ""
+	}
+
+	return f.current.ProfileName
+}
@@ -0,0 +1,46 @@
+package profilecreds
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockPollInterval is how often a lock held by another process is tried again
+const lockPollInterval = 100 * time.Millisecond
+
+// lockFile acquires a lock shared between processes, on the file at path. The lock is held by the
+// operating system, which releases it when the process exits, e.g. if the user interrupts an MFA prompt.
+// It waits for the lock to be released, or for ctx to be done. The returned function releases the lock.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	// The file is kept after the lock is released: removing it would let another process lock a new file
+	// while a third one still holds the lock on the removed one
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		locked, err := tryLock(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if locked {
+			// Closing the file releases the lock
+			return func() { file.Close() }, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
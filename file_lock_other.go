@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package profilecreds
+
+import "os"
+
+// tryLock always succeeds: there is no file lock on this platform, so the cache file isn't protected
+// against concurrent writes
+func tryLock(file *os.File) (bool, error) {
+	return true, nil
+}
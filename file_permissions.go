@@ -0,0 +1,30 @@
+package profilecreds
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// ErrInsecurePermissions is returned when CheckFilePermissions is set, and a config file can be read by
+// other users than its owner
+var ErrInsecurePermissions = errors.New("profilecreds: file is readable by group or others")
+
+// checkFilePermissions returns an error wrapping ErrInsecurePermissions if filename can be read by the
+// group or others. Missing files are ignored, and so are permissions on Windows, which uses ACLs instead.
+func checkFilePermissions(filename string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil
+	}
+	if info.Mode().Perm()&0044 != 0 {
+		return fmt.Errorf("%w: %s has mode %v, run chmod 600 %s", ErrInsecurePermissions, filename, info.Mode().Perm(), filename)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package profilecreds
+
+// KeyNames are the names of the keys read from the sections of a profile. The zero value of a field
+// means the name used by the AWS CLI.
+type KeyNames struct {
+	RoleARN              string
+	SourceProfile        string
+	CredentialSource     string
+	MFASerial            string
+	ExternalID           string
+	RoleSessionName      string
+	Region               string
+	MFAToken             string
+	STSRegionalEndpoints string
+	ExpiryWindowSeconds  string
+	MaxCachedAgeSeconds  string
+	SourceIdentity       string
+}
+
+// cliKeyNames are the key names used by the AWS CLI
+var cliKeyNames = KeyNames{
+	RoleARN:              "role_arn",
+	SourceProfile:        "source_profile",
+	CredentialSource:     "credential_source",
+	MFASerial:            "mfa_serial",
+	ExternalID:           "external_id",
+	RoleSessionName:      "role_session_name",
+	Region:               "region",
+	MFAToken:             "mfa_token",
+	STSRegionalEndpoints: "sts_regional_endpoints",
+	ExpiryWindowSeconds:  "expiry_window_seconds",
+	MaxCachedAgeSeconds:  "max_cached_age_seconds",
+	SourceIdentity:       "source_identity",
+}
+
+// keyNames returns KeyNames, with the names of the AWS CLI for the fields not set
+func (p *AssumeRoleProfileProvider) keyNames() KeyNames {
+	names := cliKeyNames
+	if p.KeyNames == nil {
+		return names
+	}
+
+	for _, f := range []struct {
+		name *string
+		set  string
+	}{
+		{&names.RoleARN, p.KeyNames.RoleARN},
+		{&names.SourceProfile, p.KeyNames.SourceProfile},
+		{&names.CredentialSource, p.KeyNames.CredentialSource},
+		{&names.MFASerial, p.KeyNames.MFASerial},
+		{&names.ExternalID, p.KeyNames.ExternalID},
+		{&names.RoleSessionName, p.KeyNames.RoleSessionName},
+		{&names.Region, p.KeyNames.Region},
+		{&names.MFAToken, p.KeyNames.MFAToken},
+		{&names.STSRegionalEndpoints, p.KeyNames.STSRegionalEndpoints},
+		{&names.ExpiryWindowSeconds, p.KeyNames.ExpiryWindowSeconds},
+		{&names.MaxCachedAgeSeconds, p.KeyNames.MaxCachedAgeSeconds},
+		{&names.SourceIdentity, p.KeyNames.SourceIdentity},
+	} {
+		if f.set != "" {
+			*f.name = f.set
+		}
+	}
+
+	return names
+}
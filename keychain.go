@@ -0,0 +1,24 @@
+package profilecreds
+
+import "errors"
+
+// errKeychainUnsupported is returned by keychainSecret on platforms without a keychain
+var errKeychainUnsupported = errors.New("profilecreds: keychain not supported on this platform")
+
+// KeychainTOTPTokenSource returns a TokenSource computing MFA tokens from the base32 TOTP secret stored in
+// the macOS keychain, as the generic password of service and account. When the item is protected by an
+// access control requiring user presence, reading it prompts for TouchID. On other platforms, or when
+// built without cgo, the user is prompted for the token instead.
+func KeychainTOTPTokenSource(service, account string) TokenSource {
+	return func() (string, error) {
+		secret, err := keychainSecret(service, account)
+		if err == errKeychainUnsupported {
+			return PromptTokenSource()
+		}
+		if err != nil {
+			return "", err
+		}
+
+		return TOTPTokenSource(secret)()
+	}
+}
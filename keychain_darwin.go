@@ -0,0 +1,65 @@
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package profilecreds
+
+/*
+#cgo LDFLAGS: -framework CoreFoundation -framework Security
+#include <stdlib.h>
+#include <CoreFoundation/CoreFoundation.h>
+#include <Security/Security.h>
+
+// readKeychainItem copies the data of a generic password. The prompt is shown by the TouchID dialog when
+// the item requires user presence.
+static OSStatus readKeychainItem(const char *service, const char *account, const char *prompt, CFDataRef *data) {
+	CFStringRef cfService = CFStringCreateWithCString(NULL, service, kCFStringEncodingUTF8);
+	CFStringRef cfAccount = CFStringCreateWithCString(NULL, account, kCFStringEncodingUTF8);
+	CFStringRef cfPrompt = CFStringCreateWithCString(NULL, prompt, kCFStringEncodingUTF8);
+
+	const void *keys[] = {kSecClass, kSecAttrService, kSecAttrAccount, kSecReturnData, kSecMatchLimit, kSecUseOperationPrompt};
+	const void *values[] = {kSecClassGenericPassword, cfService, cfAccount, kCFBooleanTrue, kSecMatchLimitOne, cfPrompt};
+	CFDictionaryRef query = CFDictionaryCreate(NULL, keys, values, 6, &kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+
+	OSStatus status = SecItemCopyMatching(query, (CFTypeRef *)data);
+
+	CFRelease(query);
+	CFRelease(cfPrompt);
+	CFRelease(cfAccount);
+	CFRelease(cfService);
+
+	return status;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// keychainSecret reads the generic password of service and account from the keychain
+func keychainSecret(service, account string) (string, error) {
+	cService := C.CString(service)
+	defer C.free(unsafe.Pointer(cService))
+	cAccount := C.CString(account)
+	defer C.free(unsafe.Pointer(cAccount))
+	cPrompt := C.CString("read the MFA secret")
+	defer C.free(unsafe.Pointer(cPrompt))
+
+	var data C.CFDataRef
+	switch status := C.readKeychainItem(cService, cAccount, cPrompt, &data); status {
+	case C.errSecSuccess:
+	case C.errSecUserCanceled, C.errSecAuthFailed:
+		return "", errors.New("profilecreds: keychain authentication cancelled or failed")
+	case C.errSecItemNotFound:
+		return "", fmt.Errorf("profilecreds: no keychain item for service %q and account %q", service, account)
+	default:
+		return "", fmt.Errorf("profilecreds: reading keychain item for service %q: OSStatus %d", service, int(status))
+	}
+	defer C.CFRelease(C.CFTypeRef(data))
+
+	secret := C.GoBytes(unsafe.Pointer(C.CFDataGetBytePtr(data)), C.int(C.CFDataGetLength(data)))
+
+	return string(secret), nil
+}
@@ -0,0 +1,9 @@
+//go:build !darwin || !cgo
+// +build !darwin !cgo
+
+package profilecreds
+
+// keychainSecret returns errKeychainUnsupported, there's no keychain on this platform
+func keychainSecret(service, account string) (string, error) {
+	return "", errKeychainUnsupported
+}
@@ -0,0 +1,98 @@
+package profilecreds
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// ManifestEntry describes a role to assume, as listed in a manifest. The source profile is read from the
+// shared credentials file.
+type ManifestEntry struct {
+	Name          string `json:"name"`
+	RoleARN       string `json:"role_arn"`
+	SourceProfile string `json:"source_profile"`
+	MFASerial     string `json:"mfa_serial,omitempty"`
+}
+
+// LoadManifest reads a JSON manifest listing roles to assume, e.g.
+//
+//	[
+//	  {"name": "prod", "role_arn": "arn:aws:iam::123456789012:role/admin", "source_profile": "dev"}
+//	]
+func LoadManifest(r io.Reader) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("profilecreds: invalid manifest: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for i, entry := range entries {
+		if entry.Name == "" || entry.RoleARN == "" {
+			return nil, fmt.Errorf("profilecreds: manifest entry %d: name and role_arn are required", i)
+		}
+		if seen[entry.Name] {
+			return nil, fmt.Errorf("profilecreds: manifest entry %d: duplicate name %q", i, entry.Name)
+		}
+		seen[entry.Name] = true
+	}
+
+	return entries, nil
+}
+
+// ManifestProfileLoader returns a ProfileLoader loading the profiles described by entries
+func ManifestProfileLoader(entries []ManifestEntry) ProfileLoader {
+	return func(profileName string) (*Profile, error) {
+		for _, entry := range entries {
+			if entry.Name != profileName {
+				continue
+			}
+
+			prof := &Profile{
+				Name:              entry.Name,
+				RoleARN:           entry.RoleARN,
+				SourceProfileName: entry.SourceProfile,
+			}
+			if entry.MFASerial != "" {
+				prof.MFASerial = aws.String(entry.MFASerial)
+			}
+
+			return prof, nil
+		}
+
+		return nil, fmt.Errorf("profilecreds: profile %q not in manifest", profileName)
+	}
+}
+
+// PrewarmManifest assumes all the roles listed in the manifest file, see LoadManifest and PrewarmAll.
+// The credentials and errors are keyed by entry name. An error is only returned if the manifest can't
+// be read.
+func PrewarmManifest(filename string, options ...func(*AssumeRoleProfileProvider)) (map[string]credentials.Value, map[string]error, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	entries, err := LoadManifest(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+
+	loader := ManifestProfileLoader(entries)
+	options = append(options[:len(options):len(options)], func(p *AssumeRoleProfileProvider) {
+		p.ProfileLoader = loader
+	})
+	values, errs := PrewarmAll(names, options...)
+
+	return values, errs, nil
+}
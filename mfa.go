@@ -0,0 +1,92 @@
+package profilecreds
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// MFADeviceSelector chooses one MFA device among the serial numbers configured for a profile
+type MFADeviceSelector func(serials []string) (string, error)
+
+// PromptMFADeviceSelector is the default MFADeviceSelector. It lists the devices and prompts the
+// user for the number of the device to use on stdin.
+var PromptMFADeviceSelector = func(serials []string) (string, error) {
+	for i, serial := range serials {
+		fmt.Fprintf(os.Stdout, "%d) %s\n", i+1, serial)
+	}
+	fmt.Fprint(os.Stdout, "MFA Device: ")
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+
+	i, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || i < 1 || i > len(serials) {
+		return "", fmt.Errorf("profilecreds: invalid MFA device choice %q", strings.TrimSpace(line))
+	}
+
+	return serials[i-1], nil
+}
+
+// selectMFASerial returns the MFA device to use, given the mfa_serial of the profile
+func (p *AssumeRoleProfileProvider) selectMFASerial(mfaSerial string) (string, error) {
+	var serials []string
+	for _, serial := range strings.Split(mfaSerial, ",") {
+		if serial = strings.TrimSpace(serial); serial != "" {
+			serials = append(serials, serial)
+		}
+	}
+
+	switch len(serials) {
+	case 0:
+		return "", fmt.Errorf("profilecreds: empty mfa_serial in profile %q", p.ProfileName)
+	case 1:
+		return serials[0], nil
+	}
+
+	for _, serial := range serials {
+		if serial == p.PreferredMFASerial {
+			return serial, nil
+		}
+	}
+
+	selectDevice := p.SelectMFADevice
+	if selectDevice == nil {
+		selectDevice = PromptMFADeviceSelector
+	}
+
+	return selectDevice(serials)
+}
+
+// discoverMFASerial lists the MFA devices of the user owning the credentials of sess. The serials are
+// returned in the mfa_serial format, or nil if the user has no MFA device.
+func discoverMFASerial(sess *session.Session) (*string, error) {
+	out, err := newIAM(sess).ListMFADevices(&iam.ListMFADevicesInput{})
+	if err != nil {
+		return nil, fmt.Errorf("profilecreds: listing MFA devices: %w", err)
+	}
+
+	var serials []string
+	for _, device := range out.MFADevices {
+		serials = append(serials, aws.StringValue(device.SerialNumber))
+	}
+	if len(serials) == 0 {
+		return nil, nil
+	}
+
+	return aws.String(strings.Join(serials, ",")), nil
+}
+
+// newIAM returns an IAM client using the credentials and region of sess. The endpoint of sess is reset, as
+// it may be an STS endpoint, e.g. from Endpoint or the services section of the profile.
+func newIAM(sess client.ConfigProvider) *iam.IAM {
+	return iam.New(sess, aws.NewConfig().WithEndpoint(""))
+}
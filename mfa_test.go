@@ -0,0 +1,34 @@
+package profilecreds
+
+import "testing"
+
+func TestDiscoverMFASerialEndpoint(t *testing.T) {
+	fake := &fakeSTS{}
+	p := newTestProvider(t, fake, testConfig, testCredentials, func(p *AssumeRoleProfileProvider) {
+		p.DiscoverMFASerial = true
+		p.Endpoint = "https://sts.example.com"
+	})
+
+	if _, err := p.Retrieve(); err != nil {
+		t.Fatal(err)
+	}
+
+	devices := fake.Requests("ListMFADevices")
+	if len(devices) != 1 {
+		t.Fatalf("%d ListMFADevices calls, want 1", len(devices))
+	}
+	if devices[0].Host != "iam.amazonaws.com" {
+		t.Errorf("ListMFADevices sent to %s, want iam.amazonaws.com", devices[0].Host)
+	}
+
+	assumeRole := fake.Requests("AssumeRole")
+	if len(assumeRole) != 1 {
+		t.Fatalf("%d AssumeRole calls, want 1", len(assumeRole))
+	}
+	if assumeRole[0].Host != "sts.example.com" {
+		t.Errorf("AssumeRole sent to %s, want sts.example.com", assumeRole[0].Host)
+	}
+	if got := assumeRole[0].Form.Get("SerialNumber"); got != "arn:aws:iam::123456789012:mfa/user" {
+		t.Errorf("SerialNumber = %q", got)
+	}
+}
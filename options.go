@@ -0,0 +1,58 @@
+package profilecreds
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// WithExpiryWindow sets the ExpiryWindow of the provider. It takes precedence over the
+// expiry_window_seconds key of the profile.
+func WithExpiryWindow(window time.Duration) func(*AssumeRoleProfileProvider) {
+	return func(p *AssumeRoleProfileProvider) {
+		p.ExpiryWindow = window
+	}
+}
+
+// WithSourceCredentials sets the credentials used to assume the role, instead of the ones of
+// the source_profile of the profile.
+func WithSourceCredentials(creds *credentials.Credentials) func(*AssumeRoleProfileProvider) {
+	return func(p *AssumeRoleProfileProvider) {
+		p.SourceCredentials = creds
+	}
+}
+
+// WithCacheFile sets the Cache of the provider to a FileCache at path. If path is "", the default
+// location of NewNamespaceFileCache is used, specific to the Namespace of the provider whatever the order
+// of the options: the cache is created once all the options are applied.
+func WithCacheFile(path string) func(*AssumeRoleProfileProvider) {
+	return func(p *AssumeRoleProfileProvider) {
+		p.Cache = nil
+		p.cacheFile = &path
+	}
+}
+
+// WithNamespace sets the Namespace of the provider.
+func WithNamespace(namespace string) func(*AssumeRoleProfileProvider) {
+	return func(p *AssumeRoleProfileProvider) {
+		p.Namespace = namespace
+	}
+}
+
+// WithClock sets the clock of the provider, e.g. to drive expiry deterministically in tests. It is used
+// by IsExpired, to apply the ExpiryWindow to cached credentials, and to generate session names.
+func WithClock(now func() time.Time) func(*AssumeRoleProfileProvider) {
+	return func(p *AssumeRoleProfileProvider) {
+		p.now = now
+		p.Expiry.CurrentTime = now
+	}
+}
+
+// WithRoleARN assumes arn instead of the role_arn of the profile, while keeping its other settings such as
+// source_profile, mfa_serial and external_id. This allows assuming sibling roles without a profile for each.
+// The profile may then have no role_arn, e.g. to assume a role directly from a source profile.
+func WithRoleARN(arn string) func(*AssumeRoleProfileProvider) {
+	return func(p *AssumeRoleProfileProvider) {
+		p.roleARN = arn
+	}
+}
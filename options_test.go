@@ -0,0 +1,43 @@
+package profilecreds
+
+import "testing"
+
+func TestWithCacheFileNamespace(t *testing.T) {
+	t.Setenv(CacheFileEnvVar, "")
+	want := defaultCacheFile("tool")
+
+	tests := []struct {
+		name    string
+		options []func(*AssumeRoleProfileProvider)
+	}{
+		{"namespace first", []func(*AssumeRoleProfileProvider){WithNamespace("tool"), WithCacheFile("")}},
+		{"namespace last", []func(*AssumeRoleProfileProvider){WithCacheFile(""), WithNamespace("tool")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newProvider("prod", tt.options...)
+
+			cache, ok := p.Cache.(*FileCache)
+			if !ok {
+				t.Fatalf("Cache = %T, want *FileCache", p.Cache)
+			}
+			if cache.filename != want {
+				t.Errorf("filename = %s, want %s", cache.filename, want)
+			}
+		})
+	}
+}
+
+func TestNewProviderFromOptionsNamespace(t *testing.T) {
+	t.Setenv(CacheFileEnvVar, "")
+
+	p := NewProviderFromOptions(Options{ProfileName: "prod", Namespace: "tool", FileCache: true})
+
+	cache, ok := p.Cache.(*FileCache)
+	if !ok {
+		t.Fatalf("Cache = %T, want *FileCache", p.Cache)
+	}
+	if want := defaultCacheFile("tool"); cache.filename != want {
+		t.Errorf("filename = %s, want %s", cache.filename, want)
+	}
+}
@@ -0,0 +1,45 @@
+package profilecreds
+
+import (
+	"errors"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// ProfileLoader loads a profile by name, from a source other than the AWS CLI config file
+type ProfileLoader func(profileName string) (*Profile, error)
+
+// EnvProfileLoader loads the profile from environment variables, so that no config file is needed:
+//
+//	PROFILECREDS_ROLE_ARN           role to be assumed (required)
+//	PROFILECREDS_SOURCE_PROFILE     profile of the shared credentials file used to assume the role
+//	PROFILECREDS_MFA_SERIAL         serial number or ARN of the MFA device
+//	PROFILECREDS_EXTERNAL_ID        external ID
+//	PROFILECREDS_ROLE_SESSION_NAME  session name
+//	PROFILECREDS_REGION             region used to call STS
+//
+// The profile name is only used to identify the cached credentials.
+var EnvProfileLoader ProfileLoader = func(profileName string) (*Profile, error) {
+	prof := &Profile{
+		Name:              profileName,
+		RoleARN:           os.Getenv("PROFILECREDS_ROLE_ARN"),
+		SourceProfileName: os.Getenv("PROFILECREDS_SOURCE_PROFILE"),
+		Region:            os.Getenv("PROFILECREDS_REGION"),
+	}
+	if prof.RoleARN == "" {
+		return nil, errors.New("profilecreds: PROFILECREDS_ROLE_ARN is not set")
+	}
+
+	if value, ok := os.LookupEnv("PROFILECREDS_MFA_SERIAL"); ok {
+		prof.MFASerial = aws.String(value)
+	}
+	if value, ok := os.LookupEnv("PROFILECREDS_EXTERNAL_ID"); ok {
+		prof.ExternalID = aws.String(value)
+	}
+	if value, ok := os.LookupEnv("PROFILECREDS_ROLE_SESSION_NAME"); ok {
+		prof.RoleSessionName = aws.String(value)
+	}
+
+	return prof, nil
+}
@@ -1,16 +1,28 @@
 package profilecreds
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sts"
-	"github.com/bgentry/speakeasy"
 
 	"github.com/go-ini/ini"
 	"github.com/mitchellh/go-homedir"
@@ -19,6 +31,24 @@ import (
 // ProviderName provides a name for AssumeRoleMFA provider
 const ProviderName = "AssumeRoleProfileProvider"
 
+// invalidSessionNameChars matches the characters STS rejects in session names
+var invalidSessionNameChars = regexp.MustCompile(`[^\w+=,.@-]`)
+
+// sourceIdentityPattern is the pattern STS accepts for source identities
+var sourceIdentityPattern = regexp.MustCompile(`^[\w+=,.@-]{2,64}$`)
+
+// minDuration is the shortest session duration accepted by STS
+const minDuration = 15 * time.Minute
+
+// defaultPackedPolicyWarnThreshold is the default value of PackedPolicyWarnThreshold
+const defaultPackedPolicyWarnThreshold = 90
+
+// defaultSectionPrefix is the prefix of the profile sections in the AWS CLI config file
+const defaultSectionPrefix = "profile "
+
+// defaultMaxMFAAttempts is the default value of MaxMFAAttempts
+const defaultMaxMFAAttempts = 2
+
 // DefaultDuration is the default amount of time in minutes that the credentials
 // will be valid for.
 var DefaultDuration = time.Duration(15) * time.Minute
@@ -39,10 +69,43 @@ type AssumeRoleProfileProvider struct {
 	// when using MFA in a CLI application, so as to not enter the token for each run.
 	Cache Cache
 
+	// Optional namespace, used to keep the cached credentials of different tools apart even
+	// when they share a cache.
+	Namespace string
+
 	// Optional source for the MFA token. The default is to prompt the user to enter
 	// the token on stdin.
 	GetToken TokenSource
 
+	// Maximum number of MFA tokens requested during a retrieval, when STS rejects the token
+	// (e.g. because it was already used). Defaults to 2 if not set.
+	MaxMFAAttempts int
+
+	// Optional source of the external ID, called when STS requires one and the profile has none, e.g. to
+	// prompt the user. The role is then assumed again once. By default an ExternalIDRequiredError is returned.
+	GetExternalID func() (string, error)
+
+	// If true, roles are never assumed without MFA: an error is returned if the profile has no mfa_serial.
+	RequireMFA bool
+
+	// If true and the profile has no mfa_serial, the MFA devices of the source user are listed
+	// with IAM, and used if any. This requires the iam:ListMFADevices permission.
+	DiscoverMFASerial bool
+
+	// If true and the profile has no role_arn, but an sso_account_id and an sso_role_name, the role
+	// provisioned by AWS SSO for the permission set sso_role_name is looked up with IAM, see SSORoleARN.
+	// This avoids hardcoding the hashed name of the role, which changes when it is provisioned again. The
+	// roles are listed with the source credentials, which must belong to the sso_account_id account.
+	DiscoverSSORole bool
+
+	// Optional serial number of the MFA device to use when the mfa_serial of the profile
+	// lists several devices, separated by commas.
+	PreferredMFASerial string
+
+	// Optional function choosing an MFA device when the mfa_serial of the profile lists several
+	// devices and PreferredMFASerial isn't one of them. The default is to prompt the user on stdin.
+	SelectMFADevice MFADeviceSelector
+
 	// ExpiryWindow will allow the credentials to trigger refreshing prior to
 	// the credentials actually expiring. This is beneficial so race conditions
 	// with expiring credentials do not cause request to fail unexpectedly
@@ -51,13 +114,214 @@ type AssumeRoleProfileProvider struct {
 	// So a ExpiryWindow of 10s would cause calls to IsExpired() to return true
 	// 10 seconds before the credentials are actually expired.
 	//
-	// If ExpiryWindow is 0 or less it will be ignored.
+	// If ExpiryWindow is 0 or less it will be ignored, and the expiry_window_seconds
+	// key of the profile is used instead if set.
 	//
 	// If using MFA, this will fail unless a new token can be provided
 	ExpiryWindow time.Duration
+
+	// Optional interval after which credentials are refreshed, even though they are valid for Duration.
+	// E.g. with a Duration of 1 hour and a RefreshInterval of 20 minutes, credentials are refreshed every
+	// 20 minutes, and credentials handed out are always valid for at least 40 minutes. Credentials are
+	// refreshed at the earliest of their retrieval time plus RefreshInterval, and their expiration minus
+	// ExpiryWindow. Credentials cached by versions without a retrieval time only use the latter.
+	RefreshInterval time.Duration
+
+	// Optional region used to call STS, e.g. us-gov-west-1. It takes precedence over the region
+	// of the profile, and the default region of the SDK is used if neither is set.
+	Region string
+
+	// If true, cached credentials are reused after the region of the profile changed. By default they are
+	// refreshed, as credentials retrieved from a regional STS endpoint may not work in other regions, e.g.
+	// when the region isn't enabled by default. The credentials are still refreshed if the config file
+	// was modified since they were retrieved, so this mostly matters for ProfileLoader and ConfigReader.
+	IgnoreRegionChange bool
+
+	// Optional region used to call STS only, e.g. when the role lives in an account whose STS is reached
+	// in another region than the one the assumed credentials are used in. It takes precedence over Region
+	// and the region of the profile for the STS call, and doesn't affect the region of AssumedSession.
+	// The source credentials still come from source_profile.
+	STSRegion string
+
+	// Optional session name, e.g. the name of the CI job, so that the sessions of all tools show the
+	// same name in CloudTrail. It takes precedence over the AWS_ROLE_SESSION_NAME environment variable
+	// and the role_session_name of the profile. Invalid characters are replaced with "-".
+	RoleSessionName string
+
+	// Optional STS endpoint, e.g. to use a VPC endpoint. Defaults to the PROFILECREDS_STS_ENDPOINT or
+	// AWS_STS_ENDPOINT environment variables, in that order, then to the sts endpoint_url of the services
+	// section referenced by the profile, and then to the endpoint resolver of the SDK.
+	Endpoint string
+
+	// Optional HTTP client used to call STS, and to fetch the token from WebIdentityTokenURL.
+	HTTPClient *http.Client
+
+	// Optional URL serving an OIDC token, e.g. from a local agent. If set, the role is assumed with
+	// sts:AssumeRoleWithWebIdentity using the token fetched on each retrieval, and no source credentials
+	// are needed. Whoever can answer on this URL chooses the identity the role is assumed with: only use
+	// trusted endpoints, over HTTPS or a loopback address. MFA, external IDs and source identities aren't
+	// supported by web identities: an error is returned if the profile or the provider requires them.
+	WebIdentityTokenURL string
+
+	// Optional retry policy of the STS client.
+	Retryer request.Retryer
+
+	// Optional maximum number of retries of the STS client, applied if not 0. It is ignored if
+	// Retryer is set, as the retryer decides on its own.
+	MaxRetries int
+
+	// Optional credentials used to assume the role. If set, the source_profile of the profile
+	// is ignored. This is useful when the base credentials are held in memory rather than in a file.
+	SourceCredentials *credentials.Credentials
+
+	// Optional inline session policy, used to scope down the permissions of the assumed role.
+	Policy *string
+
+	// Optional ARNs of managed policies used as session policies.
+	PolicyArns []string
+
+	// Optional source identity, used to track the original principal across role chains. It takes
+	// precedence over the source_identity key of the profile.
+	SourceIdentity *string
+
+	// Optional hook called with the AssumeRole parameters right before each call to STS, e.g. to set
+	// parameters this package doesn't support. Fields managed by the provider, such as TokenCode, are
+	// set again before each call and may overwrite changes made by a previous call to the hook.
+	ModifyRequest func(*sts.AssumeRoleInput)
+
+	// Percentage of the allowed packed policy size above which a warning is logged, as session
+	// policies close to the limit may break when they grow. Defaults to 90 if not set.
+	PackedPolicyWarnThreshold int64
+
+	// Optional time limit for retrieving credentials, including loading the profile, prompting for
+	// the MFA token and calling STS. When exceeded, an error wrapping context.DeadlineExceeded is returned.
+	// The default prompt then doesn't hide the token, see ContextPromptTokenSource. A GetToken source keeps
+	// running after the time limit, so it shouldn't be interactive.
+	Timeout time.Duration
+
+	// StrictDuration controls what happens when Duration is below the STS minimum of 15 minutes.
+	// By default the duration is raised to the minimum and a warning is logged. If StrictDuration
+	// is true, a DurationTooShortError is returned instead.
+	StrictDuration bool
+
+	// Optional path of the AWS CLI config file. Defaults to the AWS_CONFIG_FILE environment variable
+	// if set, or $HOME/.aws/config.
+	ConfigFile string
+
+	// Optional path of the shared credentials file. Defaults to the AWS_SHARED_CREDENTIALS_FILE
+	// environment variable if set, or $HOME/.aws/credentials.
+	CredentialsFile string
+
+	// Optional loader for the profile, e.g. EnvProfileLoader. If set, no config file is read.
+	ProfileLoader ProfileLoader
+
+	// Optional reader for the content of the AWS CLI config file. If set, no file is accessed: the
+	// shared credentials file isn't read, and the source profile isn't checked. It is read only once.
+	ConfigReader io.Reader
+
+	// Optional prefix of the sections of the AWS CLI config file, "profile " if nil. Set it to ""
+	// to read profiles from bare [NAME] sections.
+	SectionPrefix *string
+
+	// If true, the profile is only read from the AWS CLI config file. By default, keys defined for the
+	// profile in the shared credentials file (usually $HOME/.aws/credentials) take precedence.
+	ConfigOnly bool
+
+	// If true, an error wrapping ErrInsecurePermissions is returned when the config file or the shared
+	// credentials file can be read by the group or others, like the StrictModes of SSH. This is ignored
+	// on Windows.
+	CheckFilePermissions bool
+
+	// If true, the long-lived credentials of a profile without role_arn are returned unchanged,
+	// instead of failing. This allows handling plain credentials and assumed roles uniformly.
+	AllowStaticCredentials bool
+
+	// If true, environment variables references such as ${ACCOUNT_ID} are expanded in the
+	// role_arn, mfa_serial and external_id values of the profile.
+	ExpandEnv bool
+
+	// Optional logger for warnings.
+	Logger aws.Logger
+
+	// Optional names of the keys of the profile, to read config files of other tools, e.g. with
+	// assume_role_arn instead of role_arn. The names of the AWS CLI are used by default.
+	KeyNames *KeyNames
+
+	// If true, the intermediate sessions of chained profiles, i.e. whose source profile also has a
+	// role_arn, are stored in Cache too. This avoids assuming every role of the chain again, at the cost
+	// of persisting the intermediate credentials. By default only the final credentials are cached.
+	CacheIntermediateSessions bool
+
+	// If true, cached credentials are marked as owned by Namespace, and only reused by providers with
+	// the same Namespace. This keeps a single MFA prompt per tool, without other tools sharing the cache
+	// reading the credentials.
+	OwnerOnly bool
+
+	// If true, newly assumed credentials are checked with sts:GetCallerIdentity, and neither cached nor
+	// returned if the call fails. This keeps credentials which don't work, e.g. because of a misrouted
+	// endpoint, out of the cache.
+	VerifyBeforeCache bool
+
+	// Optional path of a JSON file describing the last retrieved credentials, without any secret, e.g. for
+	// a sidecar to monitor them. It is written after each successful retrieval, see Status.
+	StatusFile string
+
+	// Optional channel receiving the progress of retrievals, e.g. to show it in a GUI. Events are
+	// dropped when the channel is full.
+	Events chan<- Event
+
+	// Error of an option, returned when loading the profile, see WithServiceScope
+	optionErr error
+
+	// Optional path of the FileCache set by WithCacheFile, created once all the options are applied
+	cacheFile *string
+
+	// Optional role replacing the role_arn of the profile, see WithRoleARN
+	roleARN string
+
+	// Optional clock, see WithClock
+	now func() time.Time
+
+	// Names of the profiles assuming this one as part of a chain, to detect cycles
+	chain []string
+
+	// r serializes retrievals and guards mfaTokenUsed, m guards the fields below and the expiry
+	r            sync.Mutex
+	mfaTokenUsed bool
+
+	m                sync.Mutex
+	retrieved        *creds
+	refreshAt        time.Time
+	refreshed        *creds
+	refreshedUntil   time.Time
+	expiration       time.Time
+	accountID        string
+	packedPolicySize int64
+	static           bool
+	autoRefresh      bool
+	stats            Stats
+	config           []byte
+	parsed           *parsedProfile
+}
+
+// Stats reports how often the provider had to refresh credentials, and why. This helps diagnose
+// cache thrashing, e.g. when a new provider is created for each call.
+type Stats struct {
+	// Number of times an MFA token was requested from the token source
+	TokenRequests int
+
+	// Number of refreshes because no credentials were cached for the profile
+	RefreshesNoCache int
+
+	// Number of refreshes because the cached credentials were expired
+	RefreshesExpired int
+
+	// Number of refreshes because the cached credentials were retrieved for a different configuration
+	RefreshesProfileMismatch int
 }
 
-type profile struct {
+// Profile is a profile of the AWS CLI config file, as used to assume a role
+type Profile struct {
 	// Profile name
 	Name string
 
@@ -67,19 +331,123 @@ type profile struct {
 	// Name of the source profile which has the credentials to assume the role.
 	SourceProfileName string
 
+	// Optional source of the credentials to assume the role, instead of the source profile, e.g.
+	// Ec2InstanceMetadata.
+	CredentialSource string
+
+	// Optional account and permission set of AWS SSO, used to look up the role if there's no role_arn.
+	SSOAccountID string
+	SSORoleName  string
+
 	// Optional session name, if you wish to reuse the credentials elsewhere.
 	RoleSessionName *string
 
-	// Optional serial number (hardware) or ARN (software) of the MFA device.
+	// Optional serial number (hardware) or ARN (software) of the MFA device. Several devices
+	// may be listed, separated by commas.
 	MFASerial *string
 
 	// Optional ExternalID to pass along, defaults to nil if not set.
 	ExternalID *string
+
+	// Optional region of the profile.
+	Region string
+
+	// Optional source identity.
+	SourceIdentity *string
+
+	// Optional session policies, copied from the provider.
+	Policy     *string
+	PolicyArns []string
+
+	// Optional refresh buffer, read from expiry_window_seconds.
+	ExpiryWindow time.Duration `json:"-"`
+
+	// Optional maximum age of cached credentials, read from max_cached_age_seconds. Credentials retrieved
+	// longer ago are refreshed, even if they haven't expired.
+	MaxCachedAge time.Duration `json:"-"`
+
+	// Optional pre-seeded MFA token, read from mfa_token. It is used at most once.
+	MFAToken string `json:"-"`
+
+	// Optional choice between the regional and the global STS endpoints, read from sts_regional_endpoints.
+	STSRegionalEndpoints string `json:"-"`
+
+	// Optional STS endpoint, read from the services section referenced by the profile.
+	STSEndpoint string `json:"-"`
+
+	// Modification time of the config file the profile was read from, if any.
+	ConfigModTime time.Time `json:"-"`
+
+	// Long-lived credentials of a profile without role_arn, see AllowStaticCredentials.
+	static *credentials.Value
+
+	// Whether the source profile assumes a role itself
+	chained bool
+}
+
+// identity returns a copy of the profile without the fields that don't affect the retrieved credentials
+func (p Profile) identity() Profile {
+	p.ExpiryWindow = 0
+	p.MaxCachedAge = 0
+	p.MFAToken = ""
+	p.STSRegionalEndpoints = ""
+	p.STSEndpoint = ""
+	p.ConfigModTime = time.Time{}
+	p.static = nil
+	p.chained = false
+
+	return p
 }
 
 // NewCredentials returns a pointer to a new Credentials object retrieved
 // by assuming the specified profile
 func NewCredentials(profileName string, options ...func(*AssumeRoleProfileProvider)) *credentials.Credentials {
+	return credentials.NewCredentials(newProvider(profileName, options...))
+}
+
+// AssumedSession returns a session configured with credentials retrieved by assuming the
+// specified profile. The region of the profile is applied to the session if set.
+func AssumedSession(profileName string, options ...func(*AssumeRoleProfileProvider)) (*session.Session, error) {
+	p := newProvider(profileName, options...)
+
+	prof, err := p.loadProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	creds := credentials.NewCredentials(p)
+	if _, err := creds.Get(); err != nil {
+		return nil, err
+	}
+
+	config := aws.NewConfig().WithCredentials(creds)
+	if region := p.region(prof); region != "" {
+		config = config.WithRegion(region)
+	}
+
+	return session.NewSession(config)
+}
+
+// PrewarmAll retrieves credentials for each of the given profiles, so that they are cached for later use.
+// A failure for one profile doesn't prevent the others from being retrieved: the credentials of the
+// successful profiles and the errors of the failed ones are returned, keyed by profile name.
+func PrewarmAll(profileNames []string, options ...func(*AssumeRoleProfileProvider)) (map[string]credentials.Value, map[string]error) {
+	values := make(map[string]credentials.Value)
+	errs := make(map[string]error)
+
+	for _, profileName := range profileNames {
+		value, err := newProvider(profileName, options...).Retrieve()
+		if err != nil {
+			errs[profileName] = err
+			continue
+		}
+		values[profileName] = value
+	}
+
+	return values, errs
+}
+
+func newProvider(profileName string, options ...func(*AssumeRoleProfileProvider)) *AssumeRoleProfileProvider {
 	p := &AssumeRoleProfileProvider{
 		ProfileName: profileName,
 		Duration:    DefaultDuration,
@@ -88,161 +456,1404 @@ func NewCredentials(profileName string, options ...func(*AssumeRoleProfileProvid
 	for _, option := range options {
 		option(p)
 	}
+	if p.Cache == nil && p.cacheFile != nil {
+		p.Cache = NewNamespaceFileCache(*p.cacheFile, p.Namespace)
+	}
 
-	return credentials.NewCredentials(p)
+	return p
 }
 
 // Retrieve generates a new set of temporary credentials using STS.
 func (p *AssumeRoleProfileProvider) Retrieve() (credentials.Value, error) {
+	return p.RetrieveWithContext(context.Background())
+}
+
+// RetrieveWithContext is like Retrieve, but the call to STS is cancelled when ctx is done.
+func (p *AssumeRoleProfileProvider) RetrieveWithContext(ctx credentials.Context) (credentials.Value, error) {
+	return p.retrieveWithToken(ctx, p.GetToken)
+}
+
+// RetrieveWithToken is like Retrieve, but uses token if an MFA token is needed, instead of GetToken.
+// This is handy when the token was already collected, e.g. from a form field.
+func (p *AssumeRoleProfileProvider) RetrieveWithToken(token string) (credentials.Value, error) {
+	return p.retrieveWithToken(context.Background(), func() (string, error) {
+		return validateToken(token)
+	})
+}
+
+// retrieveWithToken is like RetrieveWithContext, but uses getToken to get the MFA token if needed.
+// If getToken is nil, the user is prompted for the token.
+func (p *AssumeRoleProfileProvider) retrieveWithToken(ctx context.Context, getToken TokenSource) (credentials.Value, error) {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	value, err := p.retrieveLocked(ctx, getToken)
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("profilecreds: retrieving credentials for profile %q: %w", p.ProfileName, ctx.Err())
+	}
+	p.emit(PhaseDone, err)
+
+	return value, err
+}
+
+func (p *AssumeRoleProfileProvider) retrieveLocked(ctx context.Context, getToken TokenSource) (credentials.Value, error) {
+	p.r.Lock()
+	defer p.r.Unlock()
+
+	p.emit(PhaseLoadProfile, nil)
 	prof, err := p.loadProfile()
 	if err != nil {
 		return credentials.Value{ProviderName: ProviderName}, err
 	}
 
-	cachedCreds := p.loadCachedCreds()
-	if cachedCreds.Match(prof) && !cachedCreds.IsExpired() {
-		return cachedCreds.Credentials, nil
+	// Long-lived credentials are returned as is, they don't need caching
+	if prof.static != nil {
+		p.m.Lock()
+		p.static = true
+		p.m.Unlock()
+
+		return *prof.static, nil
 	}
-	if p.GetToken == nil {
-		p.GetToken = PromptTokenSource
+
+	window := p.expiryWindow(prof)
+
+	// Credentials retrieved by the auto refresh are returned without calling STS again
+	if refreshed := p.takeRefreshed(); refreshed != nil && p.usable(refreshed, prof, window) {
+		p.emit(PhaseCacheHit, nil)
+		p.setRetrieved(refreshed, prof, window)
+		p.writeStatus(refreshed, true)
+		return refreshed.Credentials, nil
 	}
-	credentials, expiration, err := p.retrieve(*prof)
 
-	cachedCreds = &creds{
-		Profile:     *prof,
-		Credentials: credentials,
-		Expiration:  expiration,
+	cachedCreds, ok := p.validCachedCreds(prof, window)
+	if ok {
+		p.emit(PhaseCacheHit, nil)
+		p.setRetrieved(cachedCreds, prof, window)
+		p.writeStatus(cachedCreds, true)
+		return cachedCreds.Credentials, nil
 	}
+	p.recordRefresh(cachedCreds, prof)
 
-	if cachedJSON, err := json.Marshal(cachedCreds); err == nil {
-		p.Cache.Set("credentials", string(cachedJSON))
+	cachedCreds, fromCache, err := p.retrieveShared(ctx, prof, window, getToken)
+	if err != nil {
+		return credentials.Value{ProviderName: ProviderName}, err
 	}
 
+	p.setRetrieved(cachedCreds, prof, window)
+	p.writeStatus(cachedCreds, fromCache)
+
 	return cachedCreds.Credentials, nil
 }
 
-func (p *AssumeRoleProfileProvider) loadProfile() (*profile, error) {
-	home, err := homedir.Dir()
+// retrieveShared retrieves new credentials and caches them. If the cache implements GetOrSetCache, the
+// credentials cached meanwhile by another process or goroutine are used if possible, so that only one calls
+// STS, and fromCache is true.
+func (p *AssumeRoleProfileProvider) retrieveShared(ctx context.Context, prof *Profile, window time.Duration, getToken TokenSource) (*creds, bool, error) {
+	if c, ok := p.Cache.(GetOrSetCache); ok {
+		valid := func(cachedJSON string) bool {
+			cached, err := decodeCreds(cachedJSON)
+			return err == nil && cached.ownedBy(p.Namespace) && p.usable(cached, prof, window)
+		}
+
+		var retrieved *creds
+		cachedJSON, err := c.GetOrSet(ctx, p.cacheKey(), valid, func() (string, error) {
+			var err error
+			if retrieved, err = p.retrieve(ctx, *prof, getToken); err != nil {
+				return "", err
+			}
+			p.setOwner(retrieved)
+
+			data, err := json.Marshal(retrieved)
+			return string(data), err
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		if retrieved != nil {
+			p.emit(PhaseCacheWrite, nil)
+			return retrieved, false, nil
+		}
+
+		cached, err := decodeCreds(cachedJSON)
+		if err != nil {
+			return nil, false, err
+		}
+		p.emit(PhaseCacheHit, nil)
+
+		return cached, true, nil
+	}
+
+	retrieved, err := p.retrieve(ctx, *prof, getToken)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	p.setOwner(retrieved)
+
+	if p.Cache != nil {
+		if cachedJSON, err := json.Marshal(retrieved); err == nil {
+			p.Cache.Set(p.cacheKey(), string(cachedJSON))
+			p.emit(PhaseCacheWrite, nil)
+		}
+	}
+
+	return retrieved, false, nil
+}
+
+// setOwner marks the credentials as owned by the namespace of the provider, if OwnerOnly is set
+func (p *AssumeRoleProfileProvider) setOwner(c *creds) {
+	if p.OwnerOnly {
+		c.Owner = p.Namespace
+		c.OwnerOnly = true
 	}
+}
+
+// RetrieveCachedOnly returns the cached credentials for the profile, without ever calling STS.
+// ErrRefreshRequired is returned if no credentials are cached, or if they are expired. The long-lived
+// credentials of a profile without role_arn are always returned, see AllowStaticCredentials.
+func (p *AssumeRoleProfileProvider) RetrieveCachedOnly() (credentials.Value, error) {
+	p.r.Lock()
+	defer p.r.Unlock()
 
-	config, err := ini.Load(home + "/.aws/config")
+	prof, err := p.loadProfile()
 	if err != nil {
-		return nil, err
+		return credentials.Value{ProviderName: ProviderName}, err
+	}
+
+	if prof.static != nil {
+		p.m.Lock()
+		p.static = true
+		p.m.Unlock()
+
+		return *prof.static, nil
+	}
+
+	window := p.expiryWindow(prof)
+
+	cachedCreds, ok := p.validCachedCreds(prof, window)
+	if !ok {
+		return credentials.Value{ProviderName: ProviderName}, ErrRefreshRequired
+	}
+	p.setRetrieved(cachedCreds, prof, window)
+
+	return cachedCreds.Credentials, nil
+}
+
+// CredentialsExpireWithin returns whether the cached credentials for the profile expire within d.
+// No call to STS is made. If no credentials are cached, it returns true.
+func (p *AssumeRoleProfileProvider) CredentialsExpireWithin(d time.Duration) bool {
+	cachedCreds := p.loadCachedCreds()
+	if cachedCreds.Profile.Name != p.ProfileName {
+		return true
 	}
 
-	section, err := config.GetSection("profile " + p.ProfileName)
+	return cachedCreds.Expiration.UTC().Before(p.currentTime().UTC().Add(d))
+}
+
+// RoleARN returns the ARN of the role the provider assumes, as configured in the profile.
+// No call to STS is made, which allows confirming the role before prompting for MFA.
+func (p *AssumeRoleProfileProvider) RoleARN() (string, error) {
+	prof, err := p.loadProfile()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	prof := &profile{
-		Name: p.ProfileName,
+	return prof.RoleARN, nil
+}
+
+// SourceProfile returns the name of the profile whose credentials are used to assume the role.
+// It is "" when SourceCredentials is set.
+func (p *AssumeRoleProfileProvider) SourceProfile() (string, error) {
+	prof, err := p.loadProfile()
+	if err != nil {
+		return "", err
 	}
 
-	if k, err := section.GetKey("role_arn"); err == nil {
-		prof.RoleARN = k.String()
+	return prof.SourceProfileName, nil
+}
+
+// String describes the configuration of the provider, for debugging purposes. Sensitive values
+// such as the external ID are redacted, and credentials are never included.
+func (p *AssumeRoleProfileProvider) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s{ProfileName: %q", ProviderName, p.ProfileName)
+	if prof, err := p.loadProfile(); err == nil {
+		fmt.Fprintf(&b, ", RoleARN: %q, SourceProfile: %q", prof.RoleARN, prof.SourceProfileName)
+		if prof.MFASerial != nil {
+			fmt.Fprintf(&b, ", MFASerial: %q", *prof.MFASerial)
+		}
+		if prof.ExternalID != nil {
+			b.WriteString(", ExternalID: <redacted>")
+		}
+		fmt.Fprintf(&b, ", ExpiryWindow: %s", p.expiryWindow(prof))
 	} else {
-		return nil, err
+		fmt.Fprintf(&b, ", ExpiryWindow: %s", p.ExpiryWindow)
 	}
+	fmt.Fprintf(&b, ", Duration: %s", p.Duration)
+	if p.Policy != nil || len(p.PolicyArns) > 0 {
+		b.WriteString(", Policy: <redacted>")
+	}
+	fmt.Fprintf(&b, ", Cache: %t}", p.Cache != nil)
 
-	if k, err := section.GetKey("source_profile"); err == nil {
-		prof.SourceProfileName = k.String()
-	} else {
-		return nil, err
+	return b.String()
+}
+
+// region returns Region if set, or the region of the profile
+func (p *AssumeRoleProfileProvider) region(prof *Profile) string {
+	if p.Region != "" {
+		return p.Region
 	}
 
-	if k, err := section.GetKey("mfa_serial"); err == nil {
-		prof.MFASerial = aws.String(k.String())
+	return prof.Region
+}
+
+// stsRegion returns STSRegion if set, or the region of the provider
+func (p *AssumeRoleProfileProvider) stsRegion(prof *Profile) string {
+	if p.STSRegion != "" {
+		return p.STSRegion
 	}
 
-	if k, err := section.GetKey("external_id"); err == nil {
-		prof.ExternalID = aws.String(k.String())
+	return p.region(prof)
+}
+
+// currentTime returns the time according to the clock of the provider
+func (p *AssumeRoleProfileProvider) currentTime() time.Time {
+	if p.now != nil {
+		return p.now()
 	}
 
-	if k, err := section.GetKey("role_session_name"); err == nil {
-		prof.RoleSessionName = aws.String(k.String())
+	return time.Now()
+}
+
+// roleSessionName returns the session name, from RoleSessionName, the AWS_ROLE_SESSION_NAME environment
+// variable or the role_session_name of the profile, made valid for STS. If none is set or valid, a name
+// that will hopefully end up unique is generated.
+func (p *AssumeRoleProfileProvider) roleSessionName(prof *Profile) string {
+	name := p.RoleSessionName
+	if name == "" {
+		name = os.Getenv("AWS_ROLE_SESSION_NAME")
+	}
+	if name == "" {
+		name = aws.StringValue(prof.RoleSessionName)
 	}
 
-	return prof, nil
+	name = invalidSessionNameChars.ReplaceAllString(name, "-")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	if len(name) < 2 {
+		name = fmt.Sprintf("%d", p.currentTime().UTC().UnixNano())
+	}
+
+	return name
 }
 
-func (p *AssumeRoleProfileProvider) loadCachedCreds() *creds {
-	var cached creds
+// endpoint returns Endpoint if set, the STS endpoint set in the environment, e.g. to point at a proxy,
+// or the STS endpoint of the services section of the profile
+func (p *AssumeRoleProfileProvider) endpoint(prof *Profile) string {
+	if p.Endpoint != "" {
+		return p.Endpoint
+	}
+	if endpoint := os.Getenv("PROFILECREDS_STS_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	if endpoint := os.Getenv("AWS_STS_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
 
-	if cachedJSON, ok := p.Cache.Get("credentials"); ok {
-		json.Unmarshal([]byte(cachedJSON), &cached)
+	return prof.STSEndpoint
+}
+
+// stsRegionalEndpoints returns the value of the AWS_STS_REGIONAL_ENDPOINTS environment variable if set,
+// or the sts_regional_endpoints of the profile, like the SDK does.
+func stsRegionalEndpoints(prof *Profile) string {
+	if value := os.Getenv("AWS_STS_REGIONAL_ENDPOINTS"); value != "" {
+		return value
 	}
 
-	return &cached
+	return prof.STSRegionalEndpoints
+}
+
+// expiryWindow returns ExpiryWindow if set, or the expiry window of the profile
+func (p *AssumeRoleProfileProvider) expiryWindow(prof *Profile) time.Duration {
+	if p.ExpiryWindow > 0 {
+		return p.ExpiryWindow
+	}
+
+	return prof.ExpiryWindow
+}
+
+// AccountID returns the ID of the account the credentials were last retrieved for, or "" if
+// credentials haven't been retrieved yet. It is parsed from the assumed role ARN, no call to STS is made.
+func (p *AssumeRoleProfileProvider) AccountID() string {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return p.accountID
+}
+
+// Stats returns the refresh statistics of the provider
+func (p *AssumeRoleProfileProvider) Stats() Stats {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return p.stats
 }
 
-func (p *AssumeRoleProfileProvider) retrieve(prof profile) (credentials.Value, time.Time, error) {
-	sourceCreds := credentials.NewSharedCredentials("", prof.SourceProfileName)
+// recordRefresh updates the statistics with the reason why cachedCreds can't be used for prof
+func (p *AssumeRoleProfileProvider) recordRefresh(cachedCreds *creds, prof *Profile) {
+	p.m.Lock()
+	defer p.m.Unlock()
 
-	// Apply defaults where parameters are not set.
-	if prof.RoleSessionName == nil {
-		// Try to work out a role name that will hopefully end up unique.
-		prof.RoleSessionName = aws.String(fmt.Sprintf("%d", time.Now().UTC().UnixNano()))
+	switch {
+	case cachedCreds.Profile.Name == "":
+		p.stats.RefreshesNoCache++
+	case !cachedCreds.Match(prof, p.IgnoreRegionChange), cachedCreds.IsStale(prof):
+		p.stats.RefreshesProfileMismatch++
+	default:
+		p.stats.RefreshesExpired++
 	}
-	if p.Duration == 0 {
-		// Expire as often as AWS permits.
-		p.Duration = DefaultDuration
+}
+
+// IsExpired returns if the credentials have been retrieved, and are not expired.
+func (p *AssumeRoleProfileProvider) IsExpired() bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.static {
+		return false
 	}
 
-	sess := session.New()
-	client := sts.New(sess, sess.Config.WithCredentials(sourceCreds))
+	return p.Expiry.IsExpired()
+}
+
+// setRetrieved records the expiration and account of the credentials being returned by Retrieve
+func (p *AssumeRoleProfileProvider) setRetrieved(c *creds, prof *Profile, window time.Duration) {
+	p.m.Lock()
+	defer p.m.Unlock()
 
-	params := &sts.AssumeRoleInput{
-		DurationSeconds: aws.Int64(int64(p.Duration / time.Second)),
-		RoleArn:         aws.String(prof.RoleARN),
-		RoleSessionName: prof.RoleSessionName,
-		ExternalId:      prof.ExternalID,
+	p.refreshAt = p.refreshTime(c, prof, window)
+	p.SetExpiration(p.refreshAt, 0)
+	p.retrieved = c
+	p.expiration = c.Expiration
+	p.accountID = c.AccountID
+	p.packedPolicySize = c.PackedPolicySize
+}
+
+// credentialsExpiration returns when the credentials last retrieved expire, as reported by STS. Unlike
+// ExpiresAt, it doesn't account for the refresh of the credentials before they expire.
+func (p *AssumeRoleProfileProvider) credentialsExpiration() time.Time {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return p.expiration
+}
+
+// PackedPolicySize returns the percentage of the allowed size used by the session policies and tags
+// of the last retrieved credentials, as reported by STS.
+func (p *AssumeRoleProfileProvider) PackedPolicySize() int64 {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return p.packedPolicySize
+}
+
+// packedPolicyWarnThreshold returns PackedPolicyWarnThreshold, or its default value
+func (p *AssumeRoleProfileProvider) packedPolicyWarnThreshold() int64 {
+	if p.PackedPolicyWarnThreshold <= 0 {
+		return defaultPackedPolicyWarnThreshold
 	}
-	if prof.MFASerial != nil {
-		params.SerialNumber = prof.MFASerial
 
-		token, err := p.GetToken()
-		if err != nil {
-			return credentials.Value{ProviderName: ProviderName}, time.Now(), err
-		}
-		params.TokenCode = &token
+	return p.PackedPolicyWarnThreshold
+}
+
+// LoadProfileFromReader parses the profile with the given name from an AWS CLI config file read from r.
+// No file is accessed, so the existence of the source profile isn't checked.
+func LoadProfileFromReader(r io.Reader, name string) (*Profile, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
 	}
 
-	roleOutput, err := client.AssumeRole(params)
+	config, err := loadConfig(data)
 	if err != nil {
-		return credentials.Value{ProviderName: ProviderName}, time.Now(), err
+		return nil, err
 	}
 
-	return credentials.Value{
-		AccessKeyID:     *roleOutput.Credentials.AccessKeyId,
-		SecretAccessKey: *roleOutput.Credentials.SecretAccessKey,
-		SessionToken:    *roleOutput.Credentials.SessionToken,
-		ProviderName:    ProviderName,
-	}, (*roleOutput.Credentials.Expiration).UTC(), nil
+	return newProvider(name).parseProfile(config, nil, false)
 }
 
-type creds struct {
-	Credentials credentials.Value
+func (p *AssumeRoleProfileProvider) loadProfile() (*Profile, error) {
+	if p.optionErr != nil {
+		return nil, p.optionErr
+	}
 
-	Expiration time.Time
+	prof, err := p.readProfile()
+	if err != nil {
+		return nil, err
+	}
 
-	Profile profile
-}
+	// The overrides are applied on each load rather than cached with the parsed profile, as the settings
+	// of the provider may have changed since
+	p.applyOverrides(prof)
 
-func (c *creds) Match(p *profile) bool {
-	return reflect.DeepEqual(c.Profile, *p)
+	return prof, nil
 }
 
-func (c *creds) IsExpired() bool {
-	return c.Expiration.UTC().Before(time.Now().UTC())
-}
+// readProfile returns the profile from ProfileLoader, ConfigReader or the files, without the overrides of
+// the provider
+func (p *AssumeRoleProfileProvider) readProfile() (*Profile, error) {
+	if p.ProfileLoader != nil {
+		return p.ProfileLoader(p.ProfileName)
+	}
+
+	if p.ConfigReader != nil {
+		data, err := p.configData()
+		if err != nil {
+			return nil, err
+		}
+
+		config, err := loadConfig(data)
+		if err != nil {
+			return nil, err
+		}
 
-// TokenSource provides an MFA token
-type TokenSource func() (string, error)
+		return p.parseProfile(config, nil, false)
+	}
 
-// PromptTokenSource is the default MFA token source. It prompts the user for a token on stdin.
-var PromptTokenSource = func() (string, error) {
-	return speakeasy.Ask("MFA Token: ")
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	configPath := p.configFile(home)
+	credsPath := p.credentialsFile(home)
+
+	if p.CheckFilePermissions {
+		for _, filename := range []string{configPath, credsPath} {
+			if err := checkFilePermissions(filename); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Skip parsing if neither the files nor the settings have changed since the profile was last parsed
+	configModTime, credsModTime := modTime(configPath), modTime(credsPath)
+	settings := p.parseSettings(configPath, credsPath)
+	if prof := p.parsedProfile(settings, configModTime, credsModTime); prof != nil {
+		return prof, nil
+	}
+
+	config, err := loadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The shared credentials file is optional
+	credsFile, _ := ini.Load(credsPath)
+
+	prof, err := p.parseProfile(config, credsFile, true)
+	if err != nil {
+		return nil, err
+	}
+	prof.ConfigModTime = configModTime
+
+	p.m.Lock()
+	p.parsed = &parsedProfile{
+		profile:       *prof,
+		settings:      settings,
+		configModTime: configModTime,
+		credsModTime:  credsModTime,
+	}
+	p.m.Unlock()
+
+	return prof, nil
+}
+
+// loadConfig parses the AWS CLI config file. Nested values are allowed, as in the services sections.
+// Malformed content always results in an error: a panic of the parser is recovered.
+func loadConfig(source interface{}) (config *ini.File, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			config, err = nil, fmt.Errorf("profilecreds: malformed config file: %v", r)
+		}
+	}()
+
+	return ini.LoadSources(ini.LoadOptions{AllowNestedValues: true}, source)
+}
+
+// parsedProfile is a profile parsed with the given settings, from files last modified at the given times
+type parsedProfile struct {
+	profile       Profile
+	settings      parseSettings
+	configModTime time.Time
+	credsModTime  time.Time
+}
+
+// parseSettings are the settings of the provider which affect the parsing of the profile
+type parseSettings struct {
+	profileName       string
+	configFile        string
+	credentialsFile   string
+	sectionPrefix     string
+	keyNames          KeyNames
+	roleARN           string
+	allowStatic       bool
+	configOnly        bool
+	discoverSSORole   bool
+	sourceCredentials bool
+	webIdentity       bool
+}
+
+// parseSettings returns the current settings affecting the parsing of the profile from the given files
+func (p *AssumeRoleProfileProvider) parseSettings(configFile, credentialsFile string) parseSettings {
+	return parseSettings{
+		profileName:       p.ProfileName,
+		configFile:        configFile,
+		credentialsFile:   credentialsFile,
+		sectionPrefix:     p.sectionPrefix(),
+		keyNames:          p.keyNames(),
+		roleARN:           p.roleARN,
+		allowStatic:       p.AllowStaticCredentials,
+		configOnly:        p.ConfigOnly,
+		discoverSSORole:   p.DiscoverSSORole,
+		sourceCredentials: p.SourceCredentials != nil,
+		webIdentity:       p.WebIdentityTokenURL != "",
+	}
+}
+
+// parsedProfile returns a copy of the last parsed profile if neither the files nor the settings have
+// changed since, or nil otherwise. Profiles are always parsed again with ExpandEnv, as the environment
+// may have changed.
+func (p *AssumeRoleProfileProvider) parsedProfile(settings parseSettings, configModTime, credsModTime time.Time) *Profile {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.parsed == nil || p.ExpandEnv || p.parsed.settings != settings {
+		return nil
+	}
+	if !p.parsed.configModTime.Equal(configModTime) || !p.parsed.credsModTime.Equal(credsModTime) {
+		return nil
+	}
+	prof := p.parsed.profile
+
+	return &prof
+}
+
+// modTime returns the modification time of the file, or the zero time if it doesn't exist
+func modTime(filename string) time.Time {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
+
+// configFile returns the path of the AWS CLI config file: ConfigFile if set, the AWS_CONFIG_FILE
+// environment variable if set, or $HOME/.aws/config.
+func (p *AssumeRoleProfileProvider) configFile(home string) string {
+	if p.ConfigFile != "" {
+		return p.ConfigFile
+	}
+	if filename := os.Getenv("AWS_CONFIG_FILE"); filename != "" {
+		return filename
+	}
+
+	return filepath.Join(home, ".aws", "config")
+}
+
+// credentialsFile returns the path of the shared credentials file: CredentialsFile if set, the
+// AWS_SHARED_CREDENTIALS_FILE environment variable if set, or $HOME/.aws/credentials.
+func (p *AssumeRoleProfileProvider) credentialsFile(home string) string {
+	if p.CredentialsFile != "" {
+		return p.CredentialsFile
+	}
+	if filename := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); filename != "" {
+		return filename
+	}
+
+	return filepath.Join(home, ".aws", "credentials")
+}
+
+// configData returns the content of ConfigReader. It is read only once, as readers can't be rewound.
+func (p *AssumeRoleProfileProvider) configData() ([]byte, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.config == nil {
+		data, err := ioutil.ReadAll(p.ConfigReader)
+		if err != nil {
+			return nil, err
+		}
+		p.config = data
+	}
+
+	return p.config, nil
+}
+
+// parseProfile extracts the profile from the AWS CLI config file, and the optional shared credentials file.
+// If checkSource is true, the source profile must be defined in one of them. Only the keys used here are
+// looked up: other keys, such as output or cli_pager, and nested settings such as s3 are ignored.
+// The overrides of the provider aren't applied, see loadProfile.
+func (p *AssumeRoleProfileProvider) parseProfile(config, credsFile *ini.File, checkSource bool) (*Profile, error) {
+	section, err := p.profileSection(config, credsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	prof := &Profile{
+		Name: p.ProfileName,
+	}
+	keys := p.keyNames()
+
+	// The role_arn isn't needed when it is overridden, see WithRoleARN, or looked up, see DiscoverSSORole
+	if k, err := section.GetKey(keys.RoleARN); err == nil {
+		prof.RoleARN = p.expand(k.String())
+	} else if p.DiscoverSSORole && section.hasKey("sso_account_id") && section.hasKey("sso_role_name") {
+		account, _ := section.GetKey("sso_account_id")
+		role, _ := section.GetKey("sso_role_name")
+		prof.SSOAccountID, prof.SSORoleName = account.String(), role.String()
+	} else if p.roleARN == "" {
+		if !p.AllowStaticCredentials {
+			return nil, err
+		}
+		if prof.static, err = staticCredentials(section); err != nil {
+			return nil, err
+		}
+		return prof, nil
+	}
+
+	if k, err := section.GetKey(keys.CredentialSource); err == nil {
+		prof.CredentialSource = k.String()
+	}
+
+	// The source profile isn't needed when the source credentials are provided
+	if p.SourceCredentials == nil && p.WebIdentityTokenURL == "" && prof.CredentialSource == "" {
+		if k, err := section.GetKey(keys.SourceProfile); err == nil {
+			prof.SourceProfileName = k.String()
+		} else {
+			return nil, err
+		}
+
+		if checkSource && !sourceProfileExists(config, credsFile, p.sectionPrefix(), prof.SourceProfileName) {
+			return nil, &SourceProfileNotFoundError{
+				Profile:       prof.Name,
+				SourceProfile: prof.SourceProfileName,
+			}
+		}
+		prof.chained = sourceHasRole(config, p.sectionPrefix(), prof.SourceProfileName, keys.RoleARN)
+	}
+
+	if k, err := section.GetKey(keys.MFASerial); err == nil {
+		serial, err := resolveReference(config, k.String())
+		if err != nil {
+			return nil, err
+		}
+		prof.MFASerial = aws.String(p.expand(serial))
+	}
+
+	if k, err := section.GetKey(keys.ExternalID); err == nil {
+		prof.ExternalID = aws.String(p.expand(k.String()))
+	}
+
+	if k, err := section.GetKey(keys.RoleSessionName); err == nil {
+		prof.RoleSessionName = aws.String(k.String())
+	}
+
+	if k, err := section.GetKey(keys.Region); err == nil {
+		prof.Region = k.String()
+	}
+
+	if k, err := section.GetKey("services"); err == nil {
+		prof.STSEndpoint = servicesEndpoint(config, k.String(), "sts")
+	}
+
+	if k, err := section.GetKey(keys.MFAToken); err == nil {
+		prof.MFAToken = k.String()
+	}
+
+	if k, err := section.GetKey(keys.STSRegionalEndpoints); err == nil {
+		prof.STSRegionalEndpoints = k.String()
+	}
+
+	if k, err := section.GetKey(keys.ExpiryWindowSeconds); err == nil {
+		seconds, err := k.Int64()
+		if err != nil {
+			return nil, err
+		}
+		// Out of range values would overflow the duration
+		if seconds < 0 || seconds > int64(math.MaxInt64/time.Second) {
+			return nil, fmt.Errorf("profilecreds: invalid %s %d in profile %q", keys.ExpiryWindowSeconds, seconds, prof.Name)
+		}
+		prof.ExpiryWindow = time.Duration(seconds) * time.Second
+	}
+
+	if k, err := section.GetKey(keys.MaxCachedAgeSeconds); err == nil {
+		seconds, err := k.Int64()
+		if err != nil {
+			return nil, err
+		}
+		if seconds < 0 || seconds > int64(math.MaxInt64/time.Second) {
+			return nil, fmt.Errorf("profilecreds: invalid %s %d in profile %q", keys.MaxCachedAgeSeconds, seconds, prof.Name)
+		}
+		prof.MaxCachedAge = time.Duration(seconds) * time.Second
+	}
+
+	if k, err := section.GetKey(keys.SourceIdentity); err == nil {
+		prof.SourceIdentity = aws.String(k.String())
+	}
+
+	return prof, nil
+}
+
+// resolveReference resolves values of the form @key to the value of key in the [default] section of
+// the config file, so that e.g. an MFA device shared by many profiles can be defined once:
+//
+//	[default]
+//	mfa_device = arn:aws:iam::123456789012:mfa/user
+//
+//	[profile prod]
+//	mfa_serial = @mfa_device
+//
+// Other values are returned unchanged.
+func resolveReference(config *ini.File, value string) (string, error) {
+	if !strings.HasPrefix(value, "@") {
+		return value, nil
+	}
+
+	section, err := config.GetSection("default")
+	if err != nil {
+		return "", err
+	}
+
+	k, err := section.GetKey(value[1:])
+	if err != nil {
+		return "", fmt.Errorf("profilecreds: resolving %s: %w", value, err)
+	}
+
+	return k.String(), nil
+}
+
+// staticCredentials reads long-lived credentials from a profile without role_arn
+func staticCredentials(section profileSection) (*credentials.Value, error) {
+	value := &credentials.Value{ProviderName: ProviderName}
+
+	if k, err := section.GetKey("aws_access_key_id"); err == nil {
+		value.AccessKeyID = k.String()
+	} else {
+		return nil, err
+	}
+
+	if k, err := section.GetKey("aws_secret_access_key"); err == nil {
+		value.SecretAccessKey = k.String()
+	} else {
+		return nil, err
+	}
+
+	// aws_security_token is the name used by older tools, e.g. boto 2
+	if k, err := section.GetKey("aws_session_token"); err == nil {
+		value.SessionToken = k.String()
+	} else if k, err := section.GetKey("aws_security_token"); err == nil {
+		value.SessionToken = k.String()
+	}
+
+	return value, nil
+}
+
+// applyOverrides applies the settings of the provider which take precedence over the profile
+func (p *AssumeRoleProfileProvider) applyOverrides(prof *Profile) {
+	if p.roleARN != "" {
+		prof.RoleARN = p.roleARN
+	}
+	if p.SourceIdentity != nil {
+		prof.SourceIdentity = p.SourceIdentity
+	}
+
+	prof.Policy = p.Policy
+	prof.PolicyArns = p.PolicyArns
+}
+
+// expand replaces environment variable references in value if ExpandEnv is set
+func (p *AssumeRoleProfileProvider) expand(value string) string {
+	if !p.ExpandEnv {
+		return value
+	}
+
+	return os.ExpandEnv(value)
+}
+
+// sectionPrefix returns SectionPrefix, or its default value
+func (p *AssumeRoleProfileProvider) sectionPrefix() string {
+	if p.SectionPrefix == nil {
+		return defaultSectionPrefix
+	}
+
+	return *p.SectionPrefix
+}
+
+// profileSection returns the sections defining the profile. Unless ConfigOnly is set, keys from the
+// shared credentials file take precedence over the ones from the AWS CLI config file, like the AWS CLI does.
+func (p *AssumeRoleProfileProvider) profileSection(config, credsFile *ini.File) (profileSection, error) {
+	var sections profileSection
+
+	if credsFile != nil && !p.ConfigOnly {
+		if section, err := credsFile.GetSection(p.ProfileName); err == nil {
+			sections = append(sections, section)
+		}
+	}
+
+	section, err := config.GetSection(p.sectionPrefix() + p.ProfileName)
+	if err != nil && len(sections) == 0 {
+		return nil, err
+	}
+	if err == nil {
+		sections = append(sections, section)
+	}
+
+	return sections, nil
+}
+
+// profileSection is a profile defined across several ini sections, by order of precedence
+type profileSection []*ini.Section
+
+// hasKey returns whether a section defines the key
+func (s profileSection) hasKey(name string) bool {
+	_, err := s.GetKey(name)
+	return err == nil
+}
+
+// GetKey returns the key from the first section defining it
+func (s profileSection) GetKey(name string) (*ini.Key, error) {
+	var err error
+	for _, section := range s {
+		var k *ini.Key
+		if k, err = section.GetKey(name); err == nil {
+			return k, nil
+		}
+	}
+
+	return nil, err
+}
+
+// sourceProfileExists checks that the source profile is defined either in the AWS CLI config file
+// or in the shared credentials file (usually $HOME/.aws/credentials), if any.
+func sourceProfileExists(config, credsFile *ini.File, prefix, name string) bool {
+	if _, err := config.GetSection(prefix + name); err == nil {
+		return true
+	}
+	if name == "default" {
+		if _, err := config.GetSection(name); err == nil {
+			return true
+		}
+	}
+
+	if credsFile == nil {
+		return false
+	}
+	_, err := credsFile.GetSection(name)
+
+	return err == nil
+}
+
+// servicesEndpoint returns the endpoint_url of service in the [services NAME] section of the AWS CLI
+// config file, or "" if not set:
+//
+//	[services NAME]
+//	sts =
+//	  endpoint_url = https://sts.example.com
+func servicesEndpoint(config *ini.File, name, service string) string {
+	section, err := config.GetSection("services " + name)
+	if err != nil {
+		return ""
+	}
+	k, err := section.GetKey(service)
+	if err != nil {
+		return ""
+	}
+
+	for _, nested := range k.NestedValues() {
+		parts := strings.SplitN(nested, "=", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == "endpoint_url" {
+			return strings.TrimSpace(parts[1])
+		}
+	}
+
+	return ""
+}
+
+// sourceHasRole returns whether the source profile has a role in the AWS CLI config file
+func sourceHasRole(config *ini.File, prefix, name, roleKey string) bool {
+	section, err := config.GetSection(prefix + name)
+	if err != nil && name == "default" {
+		section, err = config.GetSection(name)
+	}
+	if err != nil {
+		return false
+	}
+
+	return section.HasKey(roleKey)
+}
+
+// cacheKeyPrefix is the prefix of the keys holding credentials in the cache
+const cacheKeyPrefix = "credentials:"
+
+// cacheVersion is the version of the format of cached credentials. It must be incremented whenever
+// the meaning of an existing field changes. Entries without a version predate versioning, and are
+// decoded as version 0.
+const cacheVersion = 1
+
+// legacyCacheKey is the key under which previous versions cached the credentials of any profile
+const legacyCacheKey = "credentials"
+
+// cacheKey returns the key under which the credentials of the profile are cached. Keys are per profile
+// so that several profiles can share the same cache.
+func (p *AssumeRoleProfileProvider) cacheKey() string {
+	if p.Namespace != "" {
+		return cacheKeyPrefix + p.Namespace + "/" + p.ProfileName
+	}
+
+	return cacheKeyPrefix + p.ProfileName
+}
+
+// validCachedCreds returns the cached credentials, and whether they can be used for prof
+func (p *AssumeRoleProfileProvider) validCachedCreds(prof *Profile, window time.Duration) (*creds, bool) {
+	cachedCreds := p.loadCachedCreds()
+
+	return cachedCreds, p.usable(cachedCreds, prof, window)
+}
+
+// usable returns whether the cached credentials were retrieved for prof, and don't expire within window
+func (p *AssumeRoleProfileProvider) usable(cachedCreds *creds, prof *Profile, window time.Duration) bool {
+	return cachedCreds.Match(prof, p.IgnoreRegionChange) && !cachedCreds.IsStale(prof) && p.refreshTime(cachedCreds, prof, window).After(p.currentTime())
+}
+
+func (p *AssumeRoleProfileProvider) loadCachedCreds() *creds {
+	var cached creds
+
+	if p.Cache == nil {
+		return &cached
+	}
+
+	cachedJSON, ok := p.Cache.Get(p.cacheKey())
+	if !ok {
+		p.migrateLegacyCache()
+		cachedJSON, ok = p.Cache.Get(p.cacheKey())
+	}
+	if !ok {
+		return &cached
+	}
+
+	if decoded, err := decodeCreds(cachedJSON); err == nil && decoded.ownedBy(p.Namespace) {
+		return decoded
+	}
+
+	return &cached
+}
+
+// decodeCreds decodes credentials cached as JSON. Entries written by a newer version are rejected as a
+// whole rather than partially decoded, which triggers a refresh.
+func decodeCreds(cachedJSON string) (*creds, error) {
+	var header struct {
+		Version int
+	}
+	if err := json.Unmarshal([]byte(cachedJSON), &header); err != nil {
+		return nil, err
+	}
+	if header.Version > cacheVersion {
+		return nil, fmt.Errorf("profilecreds: unsupported cache entry version %d", header.Version)
+	}
+
+	var cached creds
+	if err := json.Unmarshal([]byte(cachedJSON), &cached); err != nil {
+		return nil, err
+	}
+
+	return &cached, nil
+}
+
+// migrateLegacyCache copies the credentials cached under the legacy single key by previous versions to the
+// key of the profile, if they were retrieved for it. This avoids prompting for MFA again after upgrading.
+func (p *AssumeRoleProfileProvider) migrateLegacyCache() {
+	if p.Namespace != "" {
+		return
+	}
+
+	cachedJSON, ok := p.Cache.Get(legacyCacheKey)
+	if !ok {
+		return
+	}
+
+	legacy, err := decodeCreds(cachedJSON)
+	if err != nil || legacy.Profile.Name != p.ProfileName {
+		return
+	}
+
+	p.Cache.Set(p.cacheKey(), cachedJSON)
+}
+
+func (p *AssumeRoleProfileProvider) retrieve(ctx context.Context, prof Profile, getToken TokenSource) (*creds, error) {
+	if p.WebIdentityTokenURL != "" {
+		return p.retrieveWebIdentity(ctx, prof)
+	}
+
+	sourceCreds, err := p.sourceCredentials(&prof)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.applyDuration(); err != nil {
+		return nil, err
+	}
+
+	config, err := p.stsConfig(&prof, sourceCreds)
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+	client := sts.New(sess)
+
+	roleARN := prof.RoleARN
+	if roleARN == "" && prof.SSORoleName != "" {
+		if roleARN, err = SSORoleARN(sess, prof.SSOAccountID, prof.SSORoleName); err != nil {
+			return nil, err
+		}
+	}
+
+	params := &sts.AssumeRoleInput{
+		DurationSeconds: aws.Int64(int64(p.Duration / time.Second)),
+		RoleArn:         aws.String(roleARN),
+		RoleSessionName: aws.String(p.roleSessionName(&prof)),
+		ExternalId:      prof.ExternalID,
+		Policy:          prof.Policy,
+		SourceIdentity:  prof.SourceIdentity,
+	}
+	if prof.SourceIdentity != nil && !sourceIdentityPattern.MatchString(*prof.SourceIdentity) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSourceIdentity, *prof.SourceIdentity)
+	}
+	for _, arn := range prof.PolicyArns {
+		params.PolicyArns = append(params.PolicyArns, &sts.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+
+	// A discovered serial isn't recorded in the profile, so that cached credentials still match it
+	mfaSerial := prof.MFASerial
+	if mfaSerial == nil && p.DiscoverMFASerial {
+		if mfaSerial, err = discoverMFASerial(sess); err != nil {
+			return nil, err
+		}
+	}
+	if mfaSerial == nil && p.RequireMFA {
+		return nil, fmt.Errorf("%w: profile %q has no mfa_serial", ErrMFARequired, prof.Name)
+	}
+	if mfaSerial != nil {
+		serial, err := p.selectMFASerial(*mfaSerial)
+		if err != nil {
+			return nil, err
+		}
+		params.SerialNumber = aws.String(serial)
+	}
+
+	var roleOutput *sts.AssumeRoleOutput
+	askedExternalID := false
+	for attempt := 1; ; attempt++ {
+		if params.SerialNumber != nil {
+			token, err := p.mfaToken(ctx, prof, getToken)
+			if err != nil {
+				return nil, err
+			}
+			params.TokenCode = &token
+		}
+		if p.ModifyRequest != nil {
+			p.ModifyRequest(params)
+		}
+
+		p.emit(PhaseCallSTS, nil)
+		roleOutput, err = client.AssumeRoleWithContext(ctx, params)
+		if err == nil {
+			break
+		}
+		err = classifySTSError(err)
+
+		var mfaErr *MFAInvalidError
+		var externalIDErr *ExternalIDRequiredError
+		switch {
+		case errors.As(err, &externalIDErr) && params.ExternalId == nil && p.GetExternalID != nil && !askedExternalID:
+			// The role requires an external ID the profile doesn't have, ask for it once
+			askedExternalID = true
+			externalID, err := p.GetExternalID()
+			if err != nil {
+				return nil, err
+			}
+			params.ExternalId = aws.String(externalID)
+		case errors.As(err, &mfaErr) && attempt < p.maxMFAAttempts():
+			// The token may have been used already, ask for a new one
+			p.log("profilecreds: MFA token rejected, requesting a new one")
+		default:
+			return nil, err
+		}
+	}
+	retrieved, err := p.newCreds(prof, roleOutput.Credentials, roleOutput.AssumedRoleUser, roleOutput.PackedPolicySize)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.verify(ctx, sess, retrieved); err != nil {
+		return nil, err
+	}
+
+	return retrieved, nil
+}
+
+// newCreds returns the credentials assumed for prof, as returned by STS
+func (p *AssumeRoleProfileProvider) newCreds(prof Profile, c *sts.Credentials, user *sts.AssumedRoleUser, packedPolicySize *int64) (*creds, error) {
+	if err := checkSTSCredentials(c); err != nil {
+		return nil, err
+	}
+
+	retrieved := &creds{
+		Version: cacheVersion,
+		Credentials: credentials.Value{
+			AccessKeyID:     *c.AccessKeyId,
+			SecretAccessKey: *c.SecretAccessKey,
+			SessionToken:    *c.SessionToken,
+			ProviderName:    ProviderName,
+		},
+		Expiration:    (*c.Expiration).UTC(),
+		Profile:       prof,
+		ConfigModTime: prof.ConfigModTime,
+		RetrievedAt:   p.currentTime().UTC(),
+	}
+	if user != nil {
+		retrieved.AccountID = accountIDFromARN(aws.StringValue(user.Arn))
+	}
+
+	if packedPolicySize != nil {
+		retrieved.PackedPolicySize = *packedPolicySize
+		if retrieved.PackedPolicySize >= p.packedPolicyWarnThreshold() {
+			p.log("profilecreds: session policies use", retrieved.PackedPolicySize, "% of the allowed packed size")
+		}
+	}
+
+	return retrieved, nil
+}
+
+// verify checks the credentials with sts:GetCallerIdentity, if VerifyBeforeCache is set
+func (p *AssumeRoleProfileProvider) verify(ctx context.Context, sess *session.Session, retrieved *creds) error {
+	if p.VerifyBeforeCache {
+		verifier := sts.New(sess, aws.NewConfig().WithCredentials(credentials.NewStaticCredentialsFromCreds(retrieved.Credentials)))
+		if _, err := verifier.GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{}); err != nil {
+			return fmt.Errorf("profilecreds: verifying the credentials of role %s: %w", retrieved.Profile.RoleARN, err)
+		}
+	}
+
+	return nil
+}
+
+// applyDuration applies the default Duration if not set, and the STS minimum
+func (p *AssumeRoleProfileProvider) applyDuration() error {
+	if p.Duration == 0 {
+		// Expire as often as AWS permits.
+		p.Duration = DefaultDuration
+	}
+	if p.Duration < minDuration {
+		if p.StrictDuration {
+			return &DurationTooShortError{Duration: p.Duration}
+		}
+
+		p.log("profilecreds: duration", p.Duration, "is below the STS minimum, using", minDuration)
+		p.Duration = minDuration
+	}
+
+	return nil
+}
+
+// stsConfig returns the configuration of the session used to call STS with sourceCreds. The region and
+// endpoint are applied to the session, so that both the source credentials and the STS client use them.
+// This matters in partitions other than aws, e.g. aws-us-gov.
+func (p *AssumeRoleProfileProvider) stsConfig(prof *Profile, sourceCreds *credentials.Credentials) (*aws.Config, error) {
+	config := aws.NewConfig().WithCredentials(sourceCreds)
+	if region := p.stsRegion(prof); region != "" {
+		config = config.WithRegion(region)
+	}
+	if endpoint := p.endpoint(prof); endpoint != "" {
+		config = config.WithEndpoint(endpoint)
+	}
+	if p.Retryer != nil {
+		config.Retryer = p.Retryer
+	} else if p.MaxRetries != 0 {
+		config = config.WithMaxRetries(p.MaxRetries)
+	}
+	if value := stsRegionalEndpoints(prof); value != "" {
+		endpoint, err := endpoints.GetSTSRegionalEndpoint(value)
+		if err != nil {
+			return nil, err
+		}
+		config = config.WithSTSRegionalEndpoint(endpoint)
+	}
+	if p.HTTPClient != nil {
+		config = config.WithHTTPClient(p.HTTPClient)
+	}
+
+	return config, nil
+}
+
+// maxMFAAttempts returns MaxMFAAttempts, or its default value
+func (p *AssumeRoleProfileProvider) maxMFAAttempts() int {
+	if p.MaxMFAAttempts <= 0 {
+		return defaultMaxMFAAttempts
+	}
+
+	return p.MaxMFAAttempts
+}
+
+// mfaToken returns the mfa_token of the profile the first time it is called, so that a token
+// pre-seeded in the config is consumed only once. The token is obtained from getToken otherwise, or
+// by prompting the user if getToken is nil. Waiting for getToken stops when ctx is done, although
+// getToken itself keeps running.
+func (p *AssumeRoleProfileProvider) mfaToken(ctx context.Context, prof Profile, getToken TokenSource) (string, error) {
+	if prof.MFAToken != "" && !p.mfaTokenUsed {
+		p.mfaTokenUsed = true
+		return validateToken(prof.MFAToken)
+	}
+
+	p.m.Lock()
+	p.stats.TokenRequests++
+	p.m.Unlock()
+	p.emit(PhasePromptMFA, nil)
+
+	if getToken == nil {
+		// An abandoned PromptTokenSource would leave the terminal without echo and consume the next line of
+		// stdin, so the prompt only hides the token when it can't be abandoned
+		if ctx.Done() == nil {
+			return PromptTokenSource()
+		}
+		return ContextPromptTokenSource(ctx)()
+	}
+
+	type result struct {
+		token string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		token, err := getToken()
+		done <- result{token, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-done:
+		return r.token, r.err
+	}
+}
+
+// checkSTSCredentials makes sure the credentials returned by STS are complete, so they can be dereferenced
+func checkSTSCredentials(c *sts.Credentials) error {
+	switch {
+	case c == nil:
+		return errors.New("profilecreds: STS returned no credentials")
+	case c.AccessKeyId == nil:
+		return errors.New("profilecreds: STS returned credentials without an access key ID")
+	case c.SecretAccessKey == nil:
+		return errors.New("profilecreds: STS returned credentials without a secret access key")
+	case c.SessionToken == nil:
+		return errors.New("profilecreds: STS returned credentials without a session token")
+	case c.Expiration == nil:
+		return errors.New("profilecreds: STS returned credentials without an expiration")
+	}
+
+	return nil
+}
+
+// accountIDFromARN extracts the account ID from an ARN such as arn:aws:sts::123456789012:assumed-role/role/session.
+// The partition (aws, aws-cn, aws-us-gov, ...) doesn't matter.
+func accountIDFromARN(arn string) string {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) < 6 || parts[0] != "arn" {
+		return ""
+	}
+
+	return parts[4]
+}
+
+type creds struct {
+	// Version of the format of the entry, see cacheVersion
+	Version int
+
+	Credentials credentials.Value
+
+	Expiration time.Time
+
+	Profile Profile
+
+	AccountID string
+
+	PackedPolicySize int64
+
+	// Modification time of the config file when the credentials were retrieved
+	ConfigModTime time.Time
+
+	// Time the credentials were retrieved at
+	RetrievedAt time.Time
+
+	// If OwnerOnly is true, only providers whose Namespace is Owner may reuse the credentials
+	OwnerOnly bool   `json:",omitempty"`
+	Owner     string `json:",omitempty"`
+}
+
+// ownedBy returns whether a provider with the given namespace may reuse the credentials
+func (c *creds) ownedBy(namespace string) bool {
+	return !c.OwnerOnly || c.Owner == namespace
+}
+
+// Match returns whether the credentials were retrieved for p. If ignoreRegion is true, the credentials
+// match even if they were retrieved in another region. Credentials cached by versions without the region
+// match any region, so that they keep being used after upgrading.
+func (c *creds) Match(p *Profile, ignoreRegion bool) bool {
+	cached, current := c.Profile.identity(), p.identity()
+	if ignoreRegion || cached.Region == "" {
+		cached.Region, current.Region = "", ""
+	}
+
+	return reflect.DeepEqual(cached, current)
+}
+
+// IsStale returns whether the config file was modified since the credentials were retrieved, in
+// which case the profile may point to another role even if it still matches. Credentials cached by
+// versions without a modification time aren't stale, so that they keep being used after upgrading.
+func (c *creds) IsStale(p *Profile) bool {
+	return !c.ConfigModTime.IsZero() && !c.ConfigModTime.Equal(p.ConfigModTime)
+}
+
+// refreshTime returns when the credentials must be refreshed: window before they expire, or RefreshInterval
+// or the max_cached_age_seconds of prof after they were retrieved if earlier
+func (p *AssumeRoleProfileProvider) refreshTime(c *creds, prof *Profile, window time.Duration) time.Time {
+	t := c.Expiration.Add(-window)
+	if p.RefreshInterval > 0 && !c.RetrievedAt.IsZero() {
+		if interval := c.RetrievedAt.Add(p.RefreshInterval); interval.Before(t) {
+			t = interval
+		}
+	}
+	if prof.MaxCachedAge > 0 {
+		// The age of credentials cached by versions without a retrieval time is unknown, they are refreshed
+		if maxAge := c.RetrievedAt.Add(prof.MaxCachedAge); maxAge.Before(t) {
+			t = maxAge
+		}
+	}
+
+	return t
+}
+
+func (p *AssumeRoleProfileProvider) log(args ...interface{}) {
+	if p.Logger != nil {
+		p.Logger.Log(args...)
+	}
 }
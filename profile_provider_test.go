@@ -0,0 +1,790 @@
+package profilecreds
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const testConfig = `
+[profile prod]
+role_arn = arn:aws:iam::123456789012:role/prod
+source_profile = dev
+`
+
+const testCredentials = `
+[dev]
+aws_access_key_id = AKIDDEV
+aws_secret_access_key = SECRETDEV
+`
+
+// writeTestFile writes content to a file named name in dir, and returns its path
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	filename := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename
+}
+
+// newTestProvider returns a provider for the prod profile of config, see withTestFiles
+func newTestProvider(t *testing.T, fake *fakeSTS, config, credentials string, options ...func(*AssumeRoleProfileProvider)) *AssumeRoleProfileProvider {
+	t.Helper()
+
+	return newProvider("prod", append([]func(*AssumeRoleProfileProvider){withTestFiles(t, fake, config, credentials)}, options...)...)
+}
+
+// withTestFiles configures a provider to read profiles from config, source credentials from credentials,
+// and to call fake instead of AWS
+func withTestFiles(t *testing.T, fake *fakeSTS, config, credentials string) func(*AssumeRoleProfileProvider) {
+	t.Helper()
+
+	// The environment of the SDK would otherwise apply, e.g. a CA bundle which doesn't work with fakeSTS
+	for _, name := range []string{"AWS_CA_BUNDLE", "AWS_STS_ENDPOINT", "PROFILECREDS_STS_ENDPOINT", "AWS_STS_REGIONAL_ENDPOINTS", "AWS_ROLE_SESSION_NAME", CacheFileEnvVar} {
+		t.Setenv(name, "")
+	}
+
+	dir := t.TempDir()
+	configFile := writeTestFile(t, dir, "config", config)
+	credentialsFile := writeTestFile(t, dir, "credentials", credentials)
+
+	return func(p *AssumeRoleProfileProvider) {
+		p.ConfigFile = configFile
+		p.CredentialsFile = credentialsFile
+		p.HTTPClient = &http.Client{Transport: fake}
+		p.Region = "us-east-1"
+		p.GetToken = func() (string, error) {
+			return "123456", nil
+		}
+	}
+}
+
+// fakeSTS answers the calls made by the SDK to STS and IAM, and records them
+type fakeSTS struct {
+	// Expiration of the returned credentials, an hour from now if zero
+	Expiration time.Time
+
+	// Optional response to AssumeRole, replacing the default one
+	AssumeRoleResponse string
+
+	m        sync.Mutex
+	requests []fakeRequest
+}
+
+// fakeRequest is a request received by fakeSTS
+type fakeRequest struct {
+	Host   string
+	Header http.Header
+	Form   url.Values
+}
+
+// AccessKeyID returns the access key ID the request was signed with
+func (r fakeRequest) AccessKeyID() string {
+	return strings.SplitN(r.credentialScope(), "/", 2)[0]
+}
+
+// SigningRegion returns the region the request was signed for
+func (r fakeRequest) SigningRegion() string {
+	parts := strings.Split(r.credentialScope(), "/")
+	if len(parts) < 3 {
+		return ""
+	}
+
+	return parts[2]
+}
+
+// credentialScope returns the Credential of the Authorization header, e.g. AKID/20060102/us-east-1/sts/aws4_request
+func (r fakeRequest) credentialScope() string {
+	auth := r.Header.Get("Authorization")
+	i := strings.Index(auth, "Credential=")
+	if i < 0 {
+		return ""
+	}
+
+	return strings.SplitN(auth[i+len("Credential="):], ",", 2)[0]
+}
+
+// Requests returns the requests received with the given action, e.g. AssumeRole
+func (f *fakeSTS) Requests(action string) []fakeRequest {
+	f.m.Lock()
+	defer f.m.Unlock()
+
+	var requests []fakeRequest
+	for _, r := range f.requests {
+		if r.Form.Get("Action") == action {
+			requests = append(requests, r)
+		}
+	}
+
+	return requests
+}
+
+// RoundTrip implements http.RoundTripper. GET requests are answered with a web identity token.
+func (f *fakeSTS) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodGet {
+		f.m.Lock()
+		f.requests = append(f.requests, fakeRequest{Host: req.URL.Host, Header: req.Header.Clone(), Form: url.Values{"Action": {"GetToken"}}})
+		f.m.Unlock()
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(strings.NewReader("OIDCTOKEN\n")),
+			Request:    req,
+		}, nil
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	f.m.Lock()
+	f.requests = append(f.requests, fakeRequest{Host: req.URL.Host, Header: req.Header.Clone(), Form: form})
+	n := len(f.requests)
+	f.m.Unlock()
+
+	expiration := f.Expiration
+	if expiration.IsZero() {
+		expiration = time.Now().Add(time.Hour)
+	}
+	credentials := fmt.Sprintf(`<Credentials>
+		<AccessKeyId>ASIA%d</AccessKeyId>
+		<SecretAccessKey>SECRET%d</SecretAccessKey>
+		<SessionToken>TOKEN%d</SessionToken>
+		<Expiration>%s</Expiration>
+	</Credentials>
+	<AssumedRoleUser>
+		<Arn>arn:aws:sts::123456789012:assumed-role/role/session</Arn>
+		<AssumedRoleId>AROA:session</AssumedRoleId>
+	</AssumedRoleUser>`, n, n, n, expiration.UTC().Format(time.RFC3339))
+
+	action := form.Get("Action")
+	var result string
+	switch action {
+	case "AssumeRole":
+		result = credentials
+		if f.AssumeRoleResponse != "" {
+			result = f.AssumeRoleResponse
+		}
+	case "AssumeRoleWithWebIdentity":
+		result = credentials
+	case "GetCallerIdentity":
+		result = `<Account>123456789012</Account><Arn>arn:aws:sts::123456789012:assumed-role/role/session</Arn><UserId>AROA:session</UserId>`
+	case "ListMFADevices":
+		result = `<IsTruncated>false</IsTruncated><MFADevices><member>
+			<UserName>user</UserName>
+			<SerialNumber>arn:aws:iam::123456789012:mfa/user</SerialNumber>
+			<EnableDate>2020-01-01T00:00:00Z</EnableDate>
+		</member></MFADevices>`
+	case "ListRoles":
+		result = `<IsTruncated>false</IsTruncated><Roles><member>
+			<Path>/aws-reserved/sso.amazonaws.com/</Path>
+			<RoleName>AWSReservedSSO_Admin_0123456789abcdef</RoleName>
+			<RoleId>AROA</RoleId>
+			<Arn>arn:aws:iam::123456789012:role/aws-reserved/sso.amazonaws.com/AWSReservedSSO_Admin_0123456789abcdef</Arn>
+			<CreateDate>2020-01-01T00:00:00Z</CreateDate>
+			<AssumeRolePolicyDocument>%7B%7D</AssumeRolePolicyDocument>
+		</member></Roles>`
+	default:
+		return nil, fmt.Errorf("unexpected action %q", action)
+	}
+
+	xml := fmt.Sprintf(`<%[1]sResponse><%[1]sResult>%[2]s</%[1]sResult><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></%[1]sResponse>`, action, result)
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/xml"}},
+		Body:       ioutil.NopCloser(strings.NewReader(xml)),
+		Request:    req,
+	}, nil
+}
+
+func TestRetrieve(t *testing.T) {
+	fake := &fakeSTS{}
+	p := newTestProvider(t, fake, testConfig, testCredentials)
+
+	value, err := p.Retrieve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.AccessKeyID != "ASIA1" {
+		t.Errorf("AccessKeyID = %q, want ASIA1", value.AccessKeyID)
+	}
+
+	requests := fake.Requests("AssumeRole")
+	if len(requests) != 1 {
+		t.Fatalf("%d AssumeRole calls, want 1", len(requests))
+	}
+	if got := requests[0].Form.Get("RoleArn"); got != "arn:aws:iam::123456789012:role/prod" {
+		t.Errorf("RoleArn = %q", got)
+	}
+	if got := requests[0].AccessKeyID(); got != "AKIDDEV" {
+		t.Errorf("signed with %q, want AKIDDEV", got)
+	}
+}
+
+func TestSourceProfileNotFound(t *testing.T) {
+	config := `
+[profile prod]
+role_arn = arn:aws:iam::123456789012:role/prod
+source_profile = missing
+`
+	fake := &fakeSTS{}
+	p := newTestProvider(t, fake, config, testCredentials)
+
+	_, err := p.Retrieve()
+
+	var notFound *SourceProfileNotFoundError
+	if !errors.As(err, &notFound) {
+		t.Fatalf("err = %v, want a SourceProfileNotFoundError", err)
+	}
+	if notFound.Profile != "prod" || notFound.SourceProfile != "missing" {
+		t.Errorf("err = %+v", notFound)
+	}
+	if n := len(fake.Requests("AssumeRole")); n != 0 {
+		t.Errorf("%d AssumeRole calls, want 0", n)
+	}
+}
+
+func TestSourceProfileInConfig(t *testing.T) {
+	config := testConfig + `
+[profile dev]
+aws_access_key_id = AKIDCONFIG
+aws_secret_access_key = SECRETCONFIG
+`
+	p := newTestProvider(t, &fakeSTS{}, config, "")
+
+	if _, err := p.loadProfile(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDurationClamp(t *testing.T) {
+	fake := &fakeSTS{}
+	p := newTestProvider(t, fake, testConfig, testCredentials, func(p *AssumeRoleProfileProvider) {
+		p.Duration = 5 * time.Minute
+	})
+
+	if _, err := p.Retrieve(); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := fake.Requests("AssumeRole")
+	if len(requests) != 1 {
+		t.Fatalf("%d AssumeRole calls, want 1", len(requests))
+	}
+	if got := requests[0].Form.Get("DurationSeconds"); got != "900" {
+		t.Errorf("DurationSeconds = %s, want 900", got)
+	}
+}
+
+func TestDurationStrict(t *testing.T) {
+	fake := &fakeSTS{}
+	p := newTestProvider(t, fake, testConfig, testCredentials, func(p *AssumeRoleProfileProvider) {
+		p.Duration = 5 * time.Minute
+		p.StrictDuration = true
+	})
+
+	_, err := p.Retrieve()
+
+	var tooShort *DurationTooShortError
+	if !errors.As(err, &tooShort) {
+		t.Fatalf("err = %v, want a DurationTooShortError", err)
+	}
+	if tooShort.Duration != 5*time.Minute {
+		t.Errorf("Duration = %s, want 5m", tooShort.Duration)
+	}
+	if n := len(fake.Requests("AssumeRole")); n != 0 {
+		t.Errorf("%d AssumeRole calls, want 0", n)
+	}
+}
+
+func TestPrewarmAllPartialFailure(t *testing.T) {
+	config := testConfig + `
+[profile staging]
+role_arn = arn:aws:iam::123456789012:role/staging
+source_profile = dev
+
+[profile broken]
+source_profile = dev
+`
+	fake := &fakeSTS{}
+
+	values, errs := PrewarmAll([]string{"prod", "broken", "missing", "staging"}, withTestFiles(t, fake, config, testCredentials))
+
+	if len(values) != 2 || values["prod"].AccessKeyID == "" || values["staging"].AccessKeyID == "" {
+		t.Errorf("values = %v, want prod and staging", values)
+	}
+	if len(errs) != 2 || errs["broken"] == nil || errs["missing"] == nil {
+		t.Errorf("errs = %v, want broken and missing", errs)
+	}
+	if n := len(fake.Requests("AssumeRole")); n != 2 {
+		t.Errorf("%d AssumeRole calls, want 2", n)
+	}
+}
+
+func TestExpiryWindowPrecedence(t *testing.T) {
+	config := testConfig + "expiry_window_seconds = 300\n"
+	expiration := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		name    string
+		options []func(*AssumeRoleProfileProvider)
+		want    time.Duration
+	}{
+		{"profile", nil, 5 * time.Minute},
+		{"option", []func(*AssumeRoleProfileProvider){WithExpiryWindow(time.Minute)}, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t, &fakeSTS{Expiration: expiration}, config, testCredentials, tt.options...)
+
+			if _, err := p.Retrieve(); err != nil {
+				t.Fatal(err)
+			}
+			if got := expiration.Sub(p.ExpiresAt()); got != tt.want {
+				t.Errorf("window = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSettingsChange(t *testing.T) {
+	config := testConfig + `
+[profile staging]
+role_arn = arn:aws:iam::123456789012:role/staging
+source_profile = dev
+`
+	fake := &fakeSTS{}
+	p := newTestProvider(t, fake, config, testCredentials)
+
+	if _, err := p.Retrieve(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The files are unchanged, but the profile must be parsed again
+	p.ProfileName = "staging"
+	p.Policy = aws.String(`{"Version":"2012-10-17"}`)
+
+	if roleARN, err := p.RoleARN(); err != nil || roleARN != "arn:aws:iam::123456789012:role/staging" {
+		t.Errorf("RoleARN = %q, %v, want role/staging", roleARN, err)
+	}
+	if _, err := p.Retrieve(); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := fake.Requests("AssumeRole")
+	if len(requests) != 2 {
+		t.Fatalf("%d AssumeRole calls, want 2", len(requests))
+	}
+	if got := requests[1].Form.Get("RoleArn"); got != "arn:aws:iam::123456789012:role/staging" {
+		t.Errorf("second call assumed %s, want role/staging", got)
+	}
+	if requests[1].Form.Get("Policy") == "" {
+		t.Error("second call without policy")
+	}
+}
+
+func TestRetrieveNilCredentials(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+	}{
+		{"no credentials", "<AssumedRoleUser><Arn>arn:aws:sts::123456789012:assumed-role/role/session</Arn></AssumedRoleUser>"},
+		{"no session token", "<Credentials><AccessKeyId>ASIA</AccessKeyId><SecretAccessKey>SECRET</SecretAccessKey></Credentials>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t, &fakeSTS{AssumeRoleResponse: tt.response}, testConfig, testCredentials)
+
+			if _, err := p.Retrieve(); err == nil || !strings.Contains(err.Error(), "STS returned") {
+				t.Errorf("err = %v, want an incomplete credentials error", err)
+			}
+		})
+	}
+}
+
+func TestTimeoutPrompt(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	previous := stdin
+	stdin = bufio.NewReader(r)
+	defer func() { stdin = previous }()
+
+	fake := &fakeSTS{}
+	p := newTestProvider(t, fake, testConfig+"mfa_serial = arn:aws:iam::123456789012:mfa/user\n", testCredentials, func(p *AssumeRoleProfileProvider) {
+		p.GetToken = nil
+		p.Timeout = 50 * time.Millisecond
+	})
+
+	if _, err := p.Retrieve(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want a deadline exceeded error", err)
+	}
+
+	// The line entered after the timeout is used by the next prompt, instead of being lost
+	fmt.Fprintln(w, "654321")
+	p.Timeout = 5 * time.Second
+	if _, err := p.Retrieve(); err != nil {
+		t.Fatal(err)
+	}
+
+	requests := fake.Requests("AssumeRole")
+	if len(requests) != 1 {
+		t.Fatalf("%d AssumeRole calls, want 1", len(requests))
+	}
+	if got := requests[0].Form.Get("TokenCode"); got != "654321" {
+		t.Errorf("TokenCode = %q, want 654321", got)
+	}
+}
+
+func TestTimeoutTokenSource(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	p := newTestProvider(t, &fakeSTS{}, testConfig+"mfa_serial = arn:aws:iam::123456789012:mfa/user\n", testCredentials, func(p *AssumeRoleProfileProvider) {
+		p.GetToken = func() (string, error) {
+			<-block
+			return "", errors.New("unblocked")
+		}
+		p.Timeout = 50 * time.Millisecond
+	})
+
+	if _, err := p.Retrieve(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want a deadline exceeded error", err)
+	}
+}
+
+func TestConfigEditRefresh(t *testing.T) {
+	fake := &fakeSTS{}
+	cache := NewFileCache(t.TempDir() + "/cache")
+	p := newTestProvider(t, fake, testConfig, testCredentials, func(p *AssumeRoleProfileProvider) {
+		p.Cache = cache
+	})
+
+	if _, err := p.Retrieve(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Retrieve(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(fake.Requests("AssumeRole")); n != 1 {
+		t.Fatalf("%d AssumeRole calls, want 1", n)
+	}
+
+	edited := time.Now().Add(time.Minute)
+	if err := os.Chtimes(p.ConfigFile, edited, edited); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Retrieve(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(fake.Requests("AssumeRole")); n != 2 {
+		t.Errorf("%d AssumeRole calls, want 2", n)
+	}
+}
+
+func TestIsStaleUnknownModTime(t *testing.T) {
+	prof := &Profile{Name: "prod", ConfigModTime: time.Now()}
+
+	if (&creds{}).IsStale(prof) {
+		t.Error("credentials without a modification time are stale")
+	}
+	if !(&creds{ConfigModTime: prof.ConfigModTime.Add(-time.Minute)}).IsStale(prof) {
+		t.Error("credentials retrieved before an edit aren't stale")
+	}
+}
+
+func TestMigrateLegacyCache(t *testing.T) {
+	legacy, err := ioutil.ReadFile("testdata/legacy_cache.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Legacy entries have no region, even if the profile has one
+	for name, config := range map[string]string{
+		"no region": testConfig,
+		"region":    testConfig + "region = eu-west-1\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			cache := NewFileCache(writeTestFile(t, t.TempDir(), "cache", string(legacy)))
+
+			fake := &fakeSTS{}
+			p := newTestProvider(t, fake, config+"mfa_serial = arn:aws:iam::123456789012:mfa/user\n", testCredentials, func(p *AssumeRoleProfileProvider) {
+				p.Cache = cache
+				p.GetToken = func() (string, error) {
+					t.Error("MFA token requested")
+					return "", errors.New("no MFA token")
+				}
+			})
+
+			value, err := p.Retrieve()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value.AccessKeyID != "ASIALEGACY" {
+				t.Errorf("AccessKeyID = %q, want ASIALEGACY", value.AccessKeyID)
+			}
+			if n := len(fake.Requests("AssumeRole")); n != 0 {
+				t.Errorf("%d AssumeRole calls, want 0", n)
+			}
+			if _, found := cache.Get(p.cacheKey()); !found {
+				t.Error("legacy credentials not migrated to the key of the profile")
+			}
+		})
+	}
+}
+
+func TestRetrieveCachedOnlyStatic(t *testing.T) {
+	config := `
+[profile prod]
+aws_access_key_id = AKIDSTATIC
+aws_secret_access_key = SECRETSTATIC
+`
+	p := newTestProvider(t, &fakeSTS{}, config, "", func(p *AssumeRoleProfileProvider) {
+		p.AllowStaticCredentials = true
+	})
+
+	value, err := p.RetrieveCachedOnly()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.AccessKeyID != "AKIDSTATIC" {
+		t.Errorf("AccessKeyID = %q, want AKIDSTATIC", value.AccessKeyID)
+	}
+	if p.IsExpired() {
+		t.Error("static credentials are expired")
+	}
+}
+
+func TestLoadProfileRealisticConfig(t *testing.T) {
+	config := `
+[default]
+region = us-east-1
+output = json
+cli_pager =
+cli_auto_prompt = on-partial
+
+[profile dev]
+region = us-west-2
+output = table
+credential_process = /usr/local/bin/helper --profile dev
+
+[profile prod]
+role_arn = arn:aws:iam::123456789012:role/prod
+source_profile = dev
+mfa_serial = arn:aws:iam::123456789012:mfa/user
+region = eu-west-1
+output = yaml
+cli_pager = less -R
+cli_timestamp_format = iso8601
+duration_seconds = 3600
+retry_mode = adaptive
+max_attempts = 5
+services = local
+s3 =
+  max_concurrent_requests = 20
+  addressing_style = path
+dynamodb =
+  endpoint_url = http://localhost:8000
+
+[sso-session corp]
+sso_start_url = https://corp.awsapps.com/start
+sso_region = us-east-1
+sso_registration_scopes = sso:account:access
+
+[services local]
+sts =
+  endpoint_url = https://sts.example.com
+s3 =
+  endpoint_url = http://localhost:9000
+
+[plugins]
+cli_legacy_plugin_path = /usr/lib/python3/site-packages
+`
+	prof, err := LoadProfileFromReader(strings.NewReader(config), "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if prof.RoleARN != "arn:aws:iam::123456789012:role/prod" || prof.SourceProfileName != "dev" || prof.Region != "eu-west-1" {
+		t.Errorf("profile = %+v", prof)
+	}
+	if prof.MFASerial == nil || *prof.MFASerial != "arn:aws:iam::123456789012:mfa/user" {
+		t.Errorf("MFASerial = %v", prof.MFASerial)
+	}
+	if prof.STSEndpoint != "https://sts.example.com" {
+		t.Errorf("STSEndpoint = %q, want https://sts.example.com", prof.STSEndpoint)
+	}
+}
+
+func TestRefreshInterval(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	clock := WithClock(func() time.Time { return now })
+
+	tests := []struct {
+		name   string
+		window time.Duration
+		want   time.Duration
+	}{
+		{"interval first", 5 * time.Minute, 20 * time.Minute},
+		{"window first", 50 * time.Minute, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t, &fakeSTS{Expiration: now.Add(time.Hour)}, testConfig, testCredentials, clock, WithExpiryWindow(tt.window), func(p *AssumeRoleProfileProvider) {
+				p.Duration = time.Hour
+				p.RefreshInterval = 20 * time.Minute
+			})
+
+			if _, err := p.Retrieve(); err != nil {
+				t.Fatal(err)
+			}
+			if got := p.ExpiresAt().Sub(now); got != tt.want {
+				t.Errorf("refreshed after %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefreshIntervalCached(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+	fake := &fakeSTS{Expiration: start.Add(time.Hour)}
+	cache := NewFileCache(t.TempDir() + "/cache")
+	configFile := ""
+
+	retrieve := func(now time.Time) {
+		t.Helper()
+
+		p := newTestProvider(t, fake, testConfig, testCredentials, WithClock(func() time.Time { return now }), func(p *AssumeRoleProfileProvider) {
+			p.Cache = cache
+			p.Duration = time.Hour
+			p.RefreshInterval = 20 * time.Minute
+			if configFile != "" {
+				p.ConfigFile = configFile
+			}
+			configFile = p.ConfigFile
+		})
+		if _, err := p.Retrieve(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	retrieve(start)
+	retrieve(start.Add(19 * time.Minute))
+	if n := len(fake.Requests("AssumeRole")); n != 1 {
+		t.Fatalf("%d AssumeRole calls before the interval, want 1", n)
+	}
+
+	retrieve(start.Add(21 * time.Minute))
+	if n := len(fake.Requests("AssumeRole")); n != 2 {
+		t.Errorf("%d AssumeRole calls after the interval, want 2", n)
+	}
+}
+
+func FuzzLoadProfileFromReader(f *testing.F) {
+	f.Add(testConfig)
+	f.Add("[profile prod]\nrole_arn = \"unterminated\nsource_profile = dev\n")
+	f.Add("[profile prod]\nrole_arn = a\nrole_arn = b\nsource_profile = dev\nsource_profile = dev\n")
+	f.Add("[profile prod]\nrole_arn = a\nsource_profile = dev\nexpiry_window_seconds = 99999999999999999999\n")
+	f.Add("[profile prod]\nrole_arn = a\nsource_profile = dev\nmfa_serial = @missing\n")
+	f.Add("[profile prod]\nrole_arn = `a`\nsource_profile = \"\"\"dev\"\"\"\nservices = x\n[services x]\nsts =\n  endpoint_url\n")
+	f.Add("[profile prod\nrole_arn")
+	f.Add("\x00[\xff]\n=")
+
+	f.Fuzz(func(t *testing.T, config string) {
+		prof, err := LoadProfileFromReader(strings.NewReader(config), "prod")
+		if err == nil && prof == nil {
+			t.Error("no profile and no error")
+		}
+	})
+}
+
+func TestMaxCachedAge(t *testing.T) {
+	start := time.Now().Truncate(time.Second)
+	fake := &fakeSTS{Expiration: start.Add(time.Hour)}
+	p := newTestProvider(t, fake, testConfig+"max_cached_age_seconds = 600\n", testCredentials, func(p *AssumeRoleProfileProvider) {
+		p.Cache = NewFileCache(t.TempDir() + "/cache")
+	})
+
+	// A new provider for each retrieval, so that the credentials come from the cache
+	retrieve := func(now time.Time) {
+		t.Helper()
+
+		cached := p.clone()
+		WithClock(func() time.Time { return now })(cached)
+		if _, err := cached.Retrieve(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	retrieve(start)
+	retrieve(start.Add(9 * time.Minute))
+	if n := len(fake.Requests("AssumeRole")); n != 1 {
+		t.Fatalf("%d AssumeRole calls before the maximum age, want 1", n)
+	}
+
+	retrieve(start.Add(11 * time.Minute))
+	if n := len(fake.Requests("AssumeRole")); n != 2 {
+		t.Errorf("%d AssumeRole calls after the maximum age, want 2", n)
+	}
+}
+
+func TestRegionChange(t *testing.T) {
+	tests := []struct {
+		name   string
+		ignore bool
+		want   int
+	}{
+		{"refresh", false, 2},
+		{"ignore", true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			region := "us-east-1"
+			fake := &fakeSTS{}
+			p := newTestProvider(t, fake, "", testCredentials, func(p *AssumeRoleProfileProvider) {
+				p.Cache = NewFileCache(t.TempDir() + "/cache")
+				p.IgnoreRegionChange = tt.ignore
+				p.ProfileLoader = func(name string) (*Profile, error) {
+					return &Profile{Name: name, RoleARN: "arn:aws:iam::123456789012:role/prod", SourceProfileName: "dev", Region: region}, nil
+				}
+			})
+
+			if _, err := p.clone().Retrieve(); err != nil {
+				t.Fatal(err)
+			}
+			region = "eu-west-1"
+			if _, err := p.clone().Retrieve(); err != nil {
+				t.Fatal(err)
+			}
+
+			if n := len(fake.Requests("AssumeRole")); n != tt.want {
+				t.Errorf("%d AssumeRole calls, want %d", n, tt.want)
+			}
+		})
+	}
+}
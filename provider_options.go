@@ -0,0 +1,73 @@
+package profilecreds
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+// Options configures a provider declaratively, e.g. from flags, see NewProviderFromOptions. The fields
+// have the meaning of the fields of AssumeRoleProfileProvider with the same name.
+type Options struct {
+	ProfileName string
+	Duration    time.Duration
+
+	Cache     Cache
+	Namespace string
+
+	// If true and Cache is nil, Cache is set to a FileCache at CacheFile, or at the default location
+	// specific to Namespace if CacheFile is "", see NewNamespaceFileCache.
+	FileCache bool
+	CacheFile string
+
+	GetToken       TokenSource
+	RequireMFA     bool
+	MaxMFAAttempts int
+
+	ExpiryWindow    time.Duration
+	RefreshInterval time.Duration
+
+	Region     string
+	STSRegion  string
+	Endpoint   string
+	MaxRetries int
+	Timeout    time.Duration
+
+	SourceCredentials *credentials.Credentials
+
+	ConfigFile      string
+	CredentialsFile string
+
+	Logger aws.Logger
+}
+
+// NewProviderFromOptions returns a new provider configured with opts. Duration defaults to DefaultDuration.
+func NewProviderFromOptions(opts Options) *AssumeRoleProfileProvider {
+	p := newProvider(opts.ProfileName)
+	if opts.Duration != 0 {
+		p.Duration = opts.Duration
+	}
+
+	p.Cache = opts.Cache
+	p.Namespace = opts.Namespace
+	if p.Cache == nil && opts.FileCache {
+		p.Cache = NewNamespaceFileCache(opts.CacheFile, opts.Namespace)
+	}
+	p.GetToken = opts.GetToken
+	p.RequireMFA = opts.RequireMFA
+	p.MaxMFAAttempts = opts.MaxMFAAttempts
+	p.ExpiryWindow = opts.ExpiryWindow
+	p.RefreshInterval = opts.RefreshInterval
+	p.Region = opts.Region
+	p.STSRegion = opts.STSRegion
+	p.Endpoint = opts.Endpoint
+	p.MaxRetries = opts.MaxRetries
+	p.Timeout = opts.Timeout
+	p.SourceCredentials = opts.SourceCredentials
+	p.ConfigFile = opts.ConfigFile
+	p.CredentialsFile = opts.CredentialsFile
+	p.Logger = opts.Logger
+
+	return p
+}
@@ -0,0 +1,101 @@
+package profilecreds
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// StartAutoRefresh starts a goroutine which checks every interval whether the credentials of the provider
+// need to be refreshed, see IsExpired, and retrieves new ones if so. Credentials still valid in the Cache
+// are reused, without calling STS. The new credentials are kept until the next Retrieve, and the provider
+// reports being expired meanwhile, so that a credentials.Credentials wrapping it retrieves them on its next
+// Get. The goroutine stops when ctx is cancelled.
+//
+// If the profile uses MFA, GetToken must be set to a non-interactive source (e.g. ExecTokenSource),
+// otherwise refreshing fails with ErrInteractiveMFA. Refresh errors are reported to the Logger.
+//
+// StartAutoRefresh can only be called once per provider, subsequent calls return ErrAutoRefreshStarted.
+// interval must be positive.
+func (p *AssumeRoleProfileProvider) StartAutoRefresh(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("profilecreds: invalid auto refresh interval %s", interval)
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.autoRefresh {
+		return ErrAutoRefreshStarted
+	}
+	p.autoRefresh = true
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.refresh(ctx)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// refresh retrieves new credentials if the current ones need to be refreshed, unless credentials refreshed
+// earlier are still waiting for Retrieve. The expiry of the provider already accounts for the expiry window
+// and RefreshInterval, and the retrieval reuses valid cached credentials.
+func (p *AssumeRoleProfileProvider) refresh(ctx context.Context) {
+	if !p.IsExpired() || p.hasRefreshed() {
+		return
+	}
+
+	getToken := p.GetToken
+	if getToken == nil {
+		getToken = func() (string, error) {
+			return "", ErrInteractiveMFA
+		}
+	}
+
+	if _, err := p.retrieveWithToken(ctx, getToken); err != nil {
+		p.log("profilecreds: auto refresh of profile", p.ProfileName, "failed:", err)
+		return
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	// Long-lived credentials never expire, so they are never refreshed
+	if p.static {
+		return
+	}
+
+	// Wrappers only call Retrieve once the provider is expired, which returns the refreshed credentials
+	p.refreshed, p.refreshedUntil = p.retrieved, p.refreshAt
+	p.SetExpiration(time.Time{}, 0)
+}
+
+// hasRefreshed returns whether credentials refreshed in the background are waiting for Retrieve, and can
+// still be used
+func (p *AssumeRoleProfileProvider) hasRefreshed() bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return p.refreshed != nil && p.refreshedUntil.After(p.currentTime())
+}
+
+// takeRefreshed returns the credentials refreshed in the background, if any, and forgets them
+func (p *AssumeRoleProfileProvider) takeRefreshed() *creds {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	refreshed := p.refreshed
+	p.refreshed = nil
+
+	return refreshed
+}
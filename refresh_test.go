@@ -0,0 +1,132 @@
+package profilecreds
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+func TestRefreshWithoutCache(t *testing.T) {
+	now := time.Now()
+	fake := &fakeSTS{Expiration: now.Add(time.Hour)}
+	p := newTestProvider(t, fake, testConfig, testCredentials, WithExpiryWindow(5*time.Minute), WithClock(func() time.Time {
+		return now
+	}))
+
+	p.refresh(context.Background())
+	p.refresh(context.Background())
+	if n := len(fake.Requests("AssumeRole")); n != 1 {
+		t.Fatalf("%d AssumeRole calls, want 1", n)
+	}
+
+	// Within the expiry window
+	now = now.Add(56 * time.Minute)
+	p.refresh(context.Background())
+	if n := len(fake.Requests("AssumeRole")); n != 2 {
+		t.Errorf("%d AssumeRole calls, want 2", n)
+	}
+}
+
+func TestRefreshUsesCache(t *testing.T) {
+	fake := &fakeSTS{}
+	cache := NewFileCache(t.TempDir() + "/cache")
+
+	first := newTestProvider(t, fake, testConfig, testCredentials, func(p *AssumeRoleProfileProvider) {
+		p.Cache = cache
+	})
+	first.refresh(context.Background())
+
+	second := newTestProvider(t, fake, testConfig, testCredentials, func(p *AssumeRoleProfileProvider) {
+		p.Cache = cache
+		p.ConfigFile = first.ConfigFile
+	})
+	second.refresh(context.Background())
+
+	if n := len(fake.Requests("AssumeRole")); n != 1 {
+		t.Errorf("%d AssumeRole calls, want 1", n)
+	}
+	if _, err := second.Retrieve(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(fake.Requests("AssumeRole")); n != 1 {
+		t.Errorf("%d AssumeRole calls after Retrieve, want 1", n)
+	}
+}
+
+func TestRefreshCredentialsWrapper(t *testing.T) {
+	now := time.Now()
+	fake := &fakeSTS{Expiration: now.Add(time.Hour)}
+	p := newTestProvider(t, fake, testConfig, testCredentials, WithExpiryWindow(5*time.Minute), WithClock(func() time.Time {
+		return now
+	}))
+	wrapper := credentials.NewCredentials(p)
+
+	value, err := wrapper.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.AccessKeyID != "ASIA1" {
+		t.Fatalf("AccessKeyID = %s, want ASIA1", value.AccessKeyID)
+	}
+
+	// Within the expiry window
+	now = now.Add(56 * time.Minute)
+	fake.Expiration = now.Add(time.Hour)
+	p.refresh(context.Background())
+	if !p.IsExpired() {
+		t.Error("provider not expired after refresh")
+	}
+
+	value, err = wrapper.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.AccessKeyID != "ASIA2" {
+		t.Errorf("AccessKeyID = %s, want ASIA2", value.AccessKeyID)
+	}
+	if n := len(fake.Requests("AssumeRole")); n != 2 {
+		t.Errorf("%d AssumeRole calls, want 2", n)
+	}
+	if p.IsExpired() {
+		t.Error("provider expired after Retrieve")
+	}
+}
+
+func TestRefreshNotRetrieved(t *testing.T) {
+	now := time.Now()
+	fake := &fakeSTS{Expiration: now.Add(time.Hour)}
+	p := newTestProvider(t, fake, testConfig, testCredentials, WithExpiryWindow(5*time.Minute), WithClock(func() time.Time {
+		return now
+	}))
+
+	p.refresh(context.Background())
+
+	// The refreshed credentials were never retrieved, and are now within the expiry window
+	now = now.Add(56 * time.Minute)
+	fake.Expiration = now.Add(time.Hour)
+	p.refresh(context.Background())
+
+	value, err := p.Retrieve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.AccessKeyID != "ASIA2" {
+		t.Errorf("AccessKeyID = %s, want ASIA2", value.AccessKeyID)
+	}
+	if n := len(fake.Requests("AssumeRole")); n != 2 {
+		t.Errorf("%d AssumeRole calls, want 2", n)
+	}
+}
+
+func TestStartAutoRefreshInvalidInterval(t *testing.T) {
+	p := newTestProvider(t, &fakeSTS{}, testConfig, testCredentials)
+
+	if err := p.StartAutoRefresh(context.Background(), 0); err == nil {
+		t.Error("no error for a zero interval")
+	}
+	if err := p.StartAutoRefresh(context.Background(), time.Hour); err != nil {
+		t.Errorf("StartAutoRefresh failed after an invalid interval: %v", err)
+	}
+}
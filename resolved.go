@@ -0,0 +1,68 @@
+package profilecreds
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// ResolvedProfile is the effective configuration used to assume a role, after applying the keys of the
+// config files, the environment variables and the fields of the provider.
+type ResolvedProfile struct {
+	ProfileName string
+
+	// Role to be assumed
+	RoleARN string
+
+	// Source of the credentials used to assume the role: the name of the source profile, the
+	// credential_source of the profile, or "SourceCredentials" when set on the provider
+	Source string
+
+	// MFA device(s), possibly several separated by commas, or "" if MFA isn't used
+	MFASerial string
+
+	ExternalID string
+
+	// Region and endpoint used to call STS, "" for the defaults of the SDK
+	Region   string
+	Endpoint string
+
+	// Duration of the assumed credentials
+	Duration time.Duration
+}
+
+// ResolvedProfile returns the effective configuration used to assume the role, which helps debugging
+// which role is assumed and why.
+func (p *AssumeRoleProfileProvider) ResolvedProfile() (ResolvedProfile, error) {
+	prof, err := p.loadProfile()
+	if err != nil {
+		return ResolvedProfile{}, err
+	}
+
+	resolved := ResolvedProfile{
+		ProfileName: p.ProfileName,
+		RoleARN:     prof.RoleARN,
+		Source:      prof.SourceProfileName,
+		MFASerial:   aws.StringValue(prof.MFASerial),
+		ExternalID:  aws.StringValue(prof.ExternalID),
+		Region:      p.stsRegion(prof),
+		Endpoint:    p.endpoint(prof),
+		Duration:    p.Duration,
+	}
+
+	switch {
+	case p.SourceCredentials != nil:
+		resolved.Source = "SourceCredentials"
+	case prof.CredentialSource != "":
+		resolved.Source = prof.CredentialSource
+	}
+
+	if resolved.Duration == 0 {
+		resolved.Duration = DefaultDuration
+	}
+	if resolved.Duration < minDuration && !p.StrictDuration {
+		resolved.Duration = minDuration
+	}
+
+	return resolved, nil
+}
@@ -0,0 +1,56 @@
+package profilecreds
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// scopeServices are the service prefixes accepted by WithServiceScope
+var scopeServices = map[string]bool{
+	"apigateway": true, "athena": true, "autoscaling": true, "cloudformation": true, "cloudfront": true,
+	"cloudwatch": true, "codebuild": true, "codecommit": true, "codepipeline": true, "dynamodb": true,
+	"ec2": true, "ecr": true, "ecs": true, "eks": true, "elasticbeanstalk": true,
+	"elasticloadbalancing": true, "events": true, "glue": true, "iam": true, "kinesis": true,
+	"kms": true, "lambda": true, "logs": true, "rds": true, "route53": true, "s3": true,
+	"secretsmanager": true, "sns": true, "sqs": true, "ssm": true, "states": true, "sts": true,
+}
+
+// servicePolicy is an IAM policy document allowing all the actions of a service
+type servicePolicy struct {
+	Version   string
+	Statement []servicePolicyStatement
+}
+
+type servicePolicyStatement struct {
+	Effect   string
+	Action   string
+	Resource string
+}
+
+// WithServiceScope restricts the assumed credentials to the actions of service, e.g. "s3", with an inline
+// session policy. It replaces the Policy of the provider. The permissions are still limited by those of
+// the role. An unknown service makes retrievals fail.
+func WithServiceScope(service string) func(*AssumeRoleProfileProvider) {
+	return func(p *AssumeRoleProfileProvider) {
+		if !scopeServices[service] {
+			known := make([]string, 0, len(scopeServices))
+			for s := range scopeServices {
+				known = append(known, s)
+			}
+			sort.Strings(known)
+			p.optionErr = fmt.Errorf("profilecreds: unknown service %q, expected one of %v", service, known)
+			return
+		}
+
+		policy, _ := json.Marshal(servicePolicy{
+			Version: "2012-10-17",
+			Statement: []servicePolicyStatement{
+				{Effect: "Allow", Action: service + ":*", Resource: "*"},
+			},
+		})
+		p.Policy = aws.String(string(policy))
+	}
+}
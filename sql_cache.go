@@ -0,0 +1,78 @@
+package profilecreds
+
+import (
+	"database/sql"
+	"sort"
+)
+
+// SQLCache is an implementation of Cache backed by a key/value table in a SQL database, e.g. SQLite. It
+// is safe for concurrent use, and scales better than FileCache when many roles are assumed at once.
+type SQLCache struct {
+	db *sql.DB
+}
+
+// sqlCacheSchema creates the table of SQLCache if needed
+const sqlCacheSchema = `CREATE TABLE IF NOT EXISTS profilecreds_cache (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+)`
+
+// NewSQLCache returns a new instance of SQLCache using db, creating its table on first use. The driver
+// isn't imported by this package, e.g. for SQLite:
+//
+//	import _ "github.com/mattn/go-sqlite3"
+//
+//	db, err := sql.Open("sqlite3", "/var/lib/myapp/credentials.db")
+//	cache, err := profilecreds.NewSQLCache(db)
+func NewSQLCache(db *sql.DB) (*SQLCache, error) {
+	if _, err := db.Exec(sqlCacheSchema); err != nil {
+		return nil, err
+	}
+
+	return &SQLCache{db: db}, nil
+}
+
+// Set adds a new value to the cache, overwritting any pre-existing value
+func (c *SQLCache) Set(key, value string) {
+	c.db.Exec(`INSERT INTO profilecreds_cache (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`, key, value)
+}
+
+// Get a value from the cache. found is false if the value wasn't present
+func (c *SQLCache) Get(key string) (string, bool) {
+	var value string
+	if err := c.db.QueryRow(`SELECT value FROM profilecreds_cache WHERE key = ?`, key).Scan(&value); err != nil {
+		return "", false
+	}
+
+	return value, true
+}
+
+// Keys returns the keys of all the values in the cache
+func (c *SQLCache) Keys() []string {
+	rows, err := c.db.Query(`SELECT key FROM profilecreds_cache`)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// Clear removes the credentials of all profiles from the cache. Other values are kept.
+func (c *SQLCache) Clear() error {
+	_, err := c.db.Exec(`DELETE FROM profilecreds_cache WHERE key = ? OR key LIKE ?`,
+		legacyCacheKey, cacheKeyPrefix+"%")
+
+	return err
+}
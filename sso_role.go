@@ -0,0 +1,46 @@
+package profilecreds
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+// ssoRolePath is the path of the roles provisioned by AWS SSO
+const ssoRolePath = "/aws-reserved/sso.amazonaws.com/"
+
+// SSORoleARN returns the ARN of the role provisioned by AWS SSO in accountID for permissionSet. Such roles
+// are named AWSReservedSSO_<permission set>_<hash>, and the hash can't be derived: the roles of the
+// account are listed with IAM, which requires iam:ListRoles. IAM only lists the roles of the account of the
+// credentials, so the credentials of sess must belong to accountID: cross-account lookups aren't possible.
+func SSORoleARN(sess client.ConfigProvider, accountID, permissionSet string) (string, error) {
+	prefix := "AWSReservedSSO_" + permissionSet + "_"
+
+	var arns []string
+	err := newIAM(sess).ListRolesPages(&iam.ListRolesInput{PathPrefix: aws.String(ssoRolePath)}, func(out *iam.ListRolesOutput, last bool) bool {
+		for _, role := range out.Roles {
+			if strings.HasPrefix(aws.StringValue(role.RoleName), prefix) {
+				arns = append(arns, aws.StringValue(role.Arn))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", fmt.Errorf("profilecreds: listing roles: %w", err)
+	}
+
+	switch {
+	case len(arns) == 0:
+		return "", fmt.Errorf("profilecreds: no AWS SSO role for permission set %q in the account of the credentials, which must be %s", permissionSet, accountID)
+	case len(arns) > 1:
+		return "", fmt.Errorf("profilecreds: several AWS SSO roles for permission set %q: %s", permissionSet, strings.Join(arns, ", "))
+	}
+	if account := accountIDFromARN(arns[0]); account != accountID {
+		return "", fmt.Errorf("profilecreds: AWS SSO role %s isn't in account %s", arns[0], accountID)
+	}
+
+	return arns[0], nil
+}
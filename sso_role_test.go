@@ -0,0 +1,58 @@
+package profilecreds
+
+import "testing"
+
+func TestDiscoverSSORole(t *testing.T) {
+	config := `
+[profile prod]
+sso_account_id = 123456789012
+sso_role_name = Admin
+source_profile = dev
+`
+	fake := &fakeSTS{}
+	p := newTestProvider(t, fake, config, testCredentials, func(p *AssumeRoleProfileProvider) {
+		p.DiscoverSSORole = true
+		p.Endpoint = "https://sts.example.com"
+	})
+
+	if _, err := p.Retrieve(); err != nil {
+		t.Fatal(err)
+	}
+
+	roles := fake.Requests("ListRoles")
+	if len(roles) != 1 {
+		t.Fatalf("%d ListRoles calls, want 1", len(roles))
+	}
+	if roles[0].Host != "iam.amazonaws.com" {
+		t.Errorf("ListRoles sent to %s, want iam.amazonaws.com", roles[0].Host)
+	}
+
+	assumeRole := fake.Requests("AssumeRole")
+	if len(assumeRole) != 1 {
+		t.Fatalf("%d AssumeRole calls, want 1", len(assumeRole))
+	}
+	want := "arn:aws:iam::123456789012:role/aws-reserved/sso.amazonaws.com/AWSReservedSSO_Admin_0123456789abcdef"
+	if got := assumeRole[0].Form.Get("RoleArn"); got != want {
+		t.Errorf("RoleArn = %s, want %s", got, want)
+	}
+}
+
+func TestDiscoverSSORoleOtherAccount(t *testing.T) {
+	config := `
+[profile prod]
+sso_account_id = 210987654321
+sso_role_name = Admin
+source_profile = dev
+`
+	fake := &fakeSTS{}
+	p := newTestProvider(t, fake, config, testCredentials, func(p *AssumeRoleProfileProvider) {
+		p.DiscoverSSORole = true
+	})
+
+	if _, err := p.Retrieve(); err == nil {
+		t.Error("role of another account assumed")
+	}
+	if n := len(fake.Requests("AssumeRole")); n != 0 {
+		t.Errorf("%d AssumeRole calls, want 0", n)
+	}
+}
@@ -0,0 +1,64 @@
+package profilecreds
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Status is the content of the StatusFile of the provider. It holds no secret.
+type Status struct {
+	Profile    string    `json:"profile"`
+	RoleARN    string    `json:"role_arn"`
+	AccountID  string    `json:"account_id,omitempty"`
+	Expiration time.Time `json:"expiration"`
+	FromCache  bool      `json:"from_cache"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// writeStatus writes the status of the retrieved credentials to StatusFile, if set. Failures are only
+// logged, as the status is for monitoring and shouldn't prevent the credentials from being used.
+func (p *AssumeRoleProfileProvider) writeStatus(c *creds, fromCache bool) {
+	if p.StatusFile == "" {
+		return
+	}
+
+	status := Status{
+		Profile:    p.ProfileName,
+		RoleARN:    c.Profile.RoleARN,
+		AccountID:  c.AccountID,
+		Expiration: c.Expiration,
+		FromCache:  fromCache,
+		UpdatedAt:  p.currentTime().UTC(),
+	}
+	if err := writeFileAtomic(p.StatusFile, status); err != nil {
+		p.log("profilecreds: writing status file:", err)
+	}
+}
+
+// writeFileAtomic writes v as JSON to filename, through a temporary file renamed over it, so that
+// readers never see a partial file
+func writeFileAtomic(filename string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
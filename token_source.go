@@ -0,0 +1,185 @@
+package profilecreds
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/bgentry/speakeasy"
+)
+
+// ErrInvalidToken is returned by the built-in token sources when the token isn't made of 6 digits
+var ErrInvalidToken = errors.New("profilecreds: invalid MFA token, expected 6 digits")
+
+// TokenSource provides an MFA token
+type TokenSource func() (string, error)
+
+// PromptTokenSource is the default MFA token source. It prompts the user for a token on stdin. When there's
+// no terminal and stdin is piped, e.g. `echo 123456 | mytool`, the token is read from stdin instead.
+var PromptTokenSource = func() (string, error) {
+	token, err := speakeasy.Ask("MFA Token: ")
+	if err != nil {
+		if !stdinPiped() {
+			return "", err
+		}
+
+		return readPipedToken()
+	}
+
+	return validateToken(token)
+}
+
+// stdinPiped returns whether stdin is a pipe or a file rather than a terminal
+func stdinPiped() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice == 0
+}
+
+// readPipedToken reads the MFA token from the next line of stdin
+func readPipedToken() (string, error) {
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		if err == io.EOF {
+			return "", errors.New("profilecreds: no MFA token, stdin is closed")
+		}
+		return "", err
+	}
+
+	return validateToken(line)
+}
+
+// EchoPromptTokenSource prompts the user for a token on stdin, without hiding the input. It can be used
+// instead of PromptTokenSource in terminals where disabling echo doesn't work (some IDEs, mintty, ...).
+var EchoPromptTokenSource = func() (string, error) {
+	fmt.Fprint(os.Stdout, "MFA Token: ")
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+
+	return validateToken(line)
+}
+
+// stdin is shared between calls so that buffered input isn't lost
+var stdin = bufio.NewReader(os.Stdin)
+
+// ContextPromptTokenSource returns a TokenSource prompting the user for a token on stdin, which returns
+// ctx.Err() as soon as ctx is done instead of waiting for input. Like EchoPromptTokenSource, the input
+// isn't hidden, as the terminal can't be restored reliably when a read is abandoned. A line entered after
+// cancellation isn't lost, it is used by the next prompt.
+func ContextPromptTokenSource(ctx context.Context) TokenSource {
+	return func() (string, error) {
+		fmt.Fprint(os.Stdout, "MFA Token: ")
+
+		line, err := readLineContext(ctx)
+		if err != nil && line == "" {
+			if ctx.Err() != nil {
+				fmt.Fprintln(os.Stdout)
+			}
+			return "", err
+		}
+
+		return validateToken(line)
+	}
+}
+
+// stdinLine is the result of reading a line from stdin
+type stdinLine struct {
+	line string
+	err  error
+}
+
+var (
+	// pendingLineM guards pendingLine
+	pendingLineM sync.Mutex
+
+	// pendingLine receives the line being read from stdin, if any. Reads can't be interrupted, so an
+	// abandoned read is picked up by the next call to readLineContext.
+	pendingLine chan stdinLine
+)
+
+// readLineContext reads a line from stdin, or returns ctx.Err() when ctx is done
+func readLineContext(ctx context.Context) (string, error) {
+	pendingLineM.Lock()
+	if pendingLine == nil {
+		ch := make(chan stdinLine, 1)
+		go func() {
+			line, err := stdin.ReadString('\n')
+			ch <- stdinLine{line, err}
+		}()
+		pendingLine = ch
+	}
+	ch := pendingLine
+	pendingLineM.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-ch:
+		pendingLineM.Lock()
+		pendingLine = nil
+		pendingLineM.Unlock()
+
+		return r.line, r.err
+	}
+}
+
+// ExecTokenSource returns a TokenSource that runs an external command and reads the MFA token
+// from its standard output, e.g. ExecTokenSource("op", "item", "get", "AWS", "--otp").
+// The command's stderr is forwarded so that it can interact with the user if needed.
+func ExecTokenSource(name string, args ...string) TokenSource {
+	return func() (string, error) {
+		cmd := exec.Command(name, args...)
+		cmd.Stderr = os.Stderr
+
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("profilecreds: running %s: %w", name, err)
+		}
+
+		return validateToken(string(out))
+	}
+}
+
+// ChannelTokenSource returns a TokenSource that waits for the MFA token to be sent on ch. This lets GUI
+// applications feed the token from their event loop. Waiting stops when ctx is done, or if ch is closed.
+func ChannelTokenSource(ctx context.Context, ch <-chan string) TokenSource {
+	return func() (string, error) {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case token, ok := <-ch:
+			if !ok {
+				return "", errors.New("profilecreds: MFA token channel closed")
+			}
+			return validateToken(token)
+		}
+	}
+}
+
+// validateToken trims surrounding whitespace and checks that the token is made of 6 digits
+func validateToken(token string) (string, error) {
+	token = strings.TrimSpace(token)
+
+	if len(token) != 6 {
+		return "", ErrInvalidToken
+	}
+	for _, c := range token {
+		if c < '0' || c > '9' {
+			return "", ErrInvalidToken
+		}
+	}
+
+	return token, nil
+}
@@ -0,0 +1,69 @@
+package profilecreds
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// totpStep is the time step of the codes, as used by virtual MFA devices
+	totpStep = 30 * time.Second
+
+	// totpMinRemaining is the minimum validity left for a code to be used, so that it doesn't expire
+	// on the way to STS, or because of a small clock skew. Otherwise the next code is waited for.
+	totpMinRemaining = 5 * time.Second
+)
+
+// TOTPTokenSource returns a TokenSource computing MFA tokens from the base32 shared secret of a virtual
+// MFA device, as per RFC 6238. This removes the user from the loop, and should only be used where storing
+// the secret is acceptable.
+func TOTPTokenSource(base32Secret string) TokenSource {
+	return func() (string, error) {
+		secret, err := decodeTOTPSecret(base32Secret)
+		if err != nil {
+			return "", err
+		}
+
+		now := time.Now()
+		if remaining := totpStep - time.Duration(now.UnixNano())%totpStep; remaining < totpMinRemaining {
+			time.Sleep(remaining)
+			now = now.Add(remaining)
+		}
+
+		return totpCode(secret, now), nil
+	}
+}
+
+// decodeTOTPSecret decodes a base32 secret, ignoring case, spaces and padding
+func decodeTOTPSecret(base32Secret string) ([]byte, error) {
+	cleaned := strings.ToUpper(strings.Replace(base32Secret, " ", "", -1))
+	cleaned = strings.TrimRight(cleaned, "=")
+
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(cleaned)
+	if err != nil {
+		return nil, fmt.Errorf("profilecreds: invalid TOTP secret: %w", err)
+	}
+
+	return secret, nil
+}
+
+// totpCode returns the 6 digits code for secret at time t
+func totpCode(secret []byte, t time.Time) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/int64(totpStep/time.Second)))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, see RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", code%1000000)
+}
@@ -0,0 +1,114 @@
+package profilecreds
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// maxWebIdentityTokenSize bounds the size of the token read from WebIdentityTokenURL
+const maxWebIdentityTokenSize = 64 * 1024
+
+// retrieveWebIdentity assumes the role of prof with the token fetched from WebIdentityTokenURL
+func (p *AssumeRoleProfileProvider) retrieveWebIdentity(ctx context.Context, prof Profile) (*creds, error) {
+	// AssumeRoleWithWebIdentity has no MFA, external ID or source identity parameter. Failing is safer than
+	// assuming the role without them.
+	switch {
+	case p.RequireMFA || prof.MFASerial != nil:
+		return nil, fmt.Errorf("%w: profile %q can't use MFA with a web identity token", ErrMFARequired, prof.Name)
+	case prof.ExternalID != nil:
+		return nil, fmt.Errorf("profilecreds: profile %q can't use an external_id with a web identity token", prof.Name)
+	case prof.SourceIdentity != nil:
+		return nil, fmt.Errorf("profilecreds: profile %q can't set a source identity with a web identity token", prof.Name)
+	}
+
+	token, err := p.fetchWebIdentityToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.applyDuration(); err != nil {
+		return nil, err
+	}
+
+	// AssumeRoleWithWebIdentity isn't signed, the token authenticates the caller
+	config, err := p.stsConfig(&prof, credentials.AnonymousCredentials)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := session.NewSession(config)
+	if err != nil {
+		return nil, err
+	}
+
+	params := &sts.AssumeRoleWithWebIdentityInput{
+		DurationSeconds:  aws.Int64(int64(p.Duration / time.Second)),
+		RoleArn:          aws.String(prof.RoleARN),
+		RoleSessionName:  aws.String(p.roleSessionName(&prof)),
+		Policy:           prof.Policy,
+		WebIdentityToken: aws.String(token),
+	}
+	for _, arn := range prof.PolicyArns {
+		params.PolicyArns = append(params.PolicyArns, &sts.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+
+	p.emit(PhaseCallSTS, nil)
+	output, err := sts.New(sess).AssumeRoleWithWebIdentityWithContext(ctx, params)
+	if err != nil {
+		return nil, classifySTSError(err)
+	}
+
+	retrieved, err := p.newCreds(prof, output.Credentials, output.AssumedRoleUser, output.PackedPolicySize)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.verify(ctx, sess, retrieved); err != nil {
+		return nil, err
+	}
+
+	return retrieved, nil
+}
+
+// fetchWebIdentityToken gets the OIDC token from WebIdentityTokenURL
+func (p *AssumeRoleProfileProvider) fetchWebIdentityToken(ctx context.Context) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, p.WebIdentityTokenURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	client := p.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("profilecreds: fetching web identity token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("profilecreds: fetching web identity token: unexpected status %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxWebIdentityTokenSize))
+	if err != nil {
+		return "", fmt.Errorf("profilecreds: fetching web identity token: %w", err)
+	}
+
+	token := strings.TrimSpace(string(body))
+	if token == "" {
+		return "", fmt.Errorf("profilecreds: empty web identity token from %s", p.WebIdentityTokenURL)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,66 @@
+package profilecreds
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWebIdentity(t *testing.T) {
+	fake := &fakeSTS{}
+	p := newTestProvider(t, fake, testConfig, testCredentials, func(p *AssumeRoleProfileProvider) {
+		p.WebIdentityTokenURL = "http://127.0.0.1/token"
+	})
+
+	value, err := p.Retrieve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.AccessKeyID != "ASIA2" {
+		t.Errorf("AccessKeyID = %q, want ASIA2", value.AccessKeyID)
+	}
+
+	requests := fake.Requests("AssumeRoleWithWebIdentity")
+	if len(requests) != 1 {
+		t.Fatalf("%d AssumeRoleWithWebIdentity calls, want 1", len(requests))
+	}
+	if got := requests[0].Form.Get("WebIdentityToken"); got != "OIDCTOKEN" {
+		t.Errorf("WebIdentityToken = %q, want OIDCTOKEN", got)
+	}
+}
+
+func TestWebIdentityUnsupported(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		option  func(*AssumeRoleProfileProvider)
+		wantMFA bool
+	}{
+		{"require MFA", testConfig, func(p *AssumeRoleProfileProvider) { p.RequireMFA = true }, true},
+		{"mfa_serial", testConfig + "mfa_serial = arn:aws:iam::123456789012:mfa/user\n", nil, true},
+		{"external_id", testConfig + "external_id = secret\n", nil, false},
+		{"source identity", testConfig + "source_identity = user\n", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeSTS{}
+			p := newTestProvider(t, fake, tt.config, testCredentials, func(p *AssumeRoleProfileProvider) {
+				p.WebIdentityTokenURL = "http://127.0.0.1/token"
+				if tt.option != nil {
+					tt.option(p)
+				}
+			})
+
+			_, err := p.Retrieve()
+			if err == nil || !strings.Contains(err.Error(), "with a web identity token") {
+				t.Fatalf("err = %v, want a web identity error", err)
+			}
+			if errors.Is(err, ErrMFARequired) != tt.wantMFA {
+				t.Errorf("err = %v, ErrMFARequired %t", err, tt.wantMFA)
+			}
+			if len(fake.requests) != 0 {
+				t.Errorf("%d requests, want 0", len(fake.requests))
+			}
+		})
+	}
+}